@@ -0,0 +1,272 @@
+package xedq
+
+import (
+	"fmt"
+	"sort"
+)
+
+// EdgeKind describes control flow transfer type.
+type EdgeKind uint8
+
+// All edge kinds.
+const (
+	// EdgeFallthrough is a sequential flow into the next block.
+	// Used for not-taken conditional branches and for
+	// blocks that were split because the next address is a branch target.
+	EdgeFallthrough EdgeKind = iota
+
+	// EdgeJump is an unconditional direct jump.
+	EdgeJump
+
+	// EdgeCondTaken is a taken conditional branch.
+	EdgeCondTaken
+
+	// EdgeCall is a direct call.
+	// Calls do not terminate blocks, so edge source block
+	// also has a fallthrough (or no) successor after the call instruction.
+	EdgeCall
+)
+
+func (kind EdgeKind) String() string {
+	switch kind {
+	case EdgeFallthrough:
+		return "fallthrough"
+	case EdgeJump:
+		return "jump"
+	case EdgeCondTaken:
+		return "cond"
+	case EdgeCall:
+		return "call"
+	default:
+		return "??"
+	}
+}
+
+// Edge is a control flow graph edge.
+type Edge struct {
+	Kind EdgeKind
+	From *BasicBlock
+	To   *BasicBlock
+}
+
+// BasicBlock is a straight-line instructions sequence
+// with a single entry point.
+type BasicBlock struct {
+	// Start is an address of the first block instruction.
+	Start uint64
+
+	// End is an address right after the last block instruction.
+	End uint64
+
+	// Insts are block instructions in execution order.
+	Insts []*DecodedInst
+
+	// Succs are outgoing edges.
+	Succs []*Edge
+
+	// Preds are incoming edges.
+	Preds []*Edge
+}
+
+// CFG is a control flow graph that is built by recursive traversal.
+//
+// Should be created with BuildCFG.
+type CFG struct {
+	// Entries are blocks that correspond to BuildCFG entry addresses.
+	Entries []*BasicBlock
+
+	// Blocks are all discovered blocks sorted by start address.
+	Blocks []*BasicBlock
+
+	// DecodeErrors are decoding errors by their addresses.
+	// Traversal of a path stops at undecodable instruction,
+	// which can be inline data or bytes after a call
+	// that does not return. Block that precedes such
+	// address ends there and has no fallthrough edge.
+	DecodeErrors map[uint64]error
+
+	blockByAddr map[uint64]*BasicBlock
+}
+
+// BlockAt returns block that starts at addr.
+// Returns nil if there is no such block.
+func (g *CFG) BlockAt(addr uint64) *BasicBlock {
+	return g.blockByAddr[addr]
+}
+
+// BuildCFG performs recursive-descent disassembly of code,
+// starting from entries.
+//
+// base is an address of the first code byte.
+// Direct branches and calls are followed as long as their
+// targets are inside code; indirect branches are not followed.
+// Traversal of a path stops at RET and JMP instructions
+// and at undecodable instructions, see CFG.DecodeErrors.
+// Entries that can't be decoded have nil block in CFG.Entries.
+//
+// Machine mode is taken from dec.
+func BuildCFG(dec *Decoder, code []byte, base uint64, entries ...uint64) (*CFG, error) {
+	b := cfgBuilder{
+		dec:     dec,
+		code:    code,
+		base:    base,
+		insts:   make(map[uint64]*DecodedInst),
+		leaders: make(map[uint64]bool),
+		errs:    make(map[uint64]error),
+	}
+	for _, addr := range entries {
+		if !b.inRange(addr) {
+			return nil, fmt.Errorf("cfg: entry %#x is out of code range", addr)
+		}
+		b.leaders[addr] = true
+	}
+	b.explore(entries)
+	g := b.build()
+	for _, addr := range entries {
+		g.Entries = append(g.Entries, g.blockByAddr[addr])
+	}
+	return g, nil
+}
+
+type cfgBuilder struct {
+	dec  *Decoder
+	code []byte
+	base uint64
+
+	// Decoded instructions by their addresses.
+	insts map[uint64]*DecodedInst
+
+	// Addresses that start basic blocks.
+	leaders map[uint64]bool
+
+	// Decoding errors by their addresses.
+	errs map[uint64]error
+}
+
+func (b *cfgBuilder) inRange(addr uint64) bool {
+	return addr >= b.base && addr-b.base < uint64(len(b.code))
+}
+
+// explore decodes all instructions that are reachable from roots.
+func (b *cfgBuilder) explore(roots []uint64) {
+	worklist := append([]uint64(nil), roots...)
+	for len(worklist) != 0 {
+		addr := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+
+		for b.inRange(addr) && b.insts[addr] == nil && b.errs[addr] == nil {
+			inst, err := b.dec.Decode(b.code[addr-b.base:])
+			if err != nil {
+				b.errs[addr] = err
+				break
+			}
+			b.insts[addr] = inst
+			next := addr + uint64(inst.Len())
+
+			if target, ok := branchTarget(inst, addr); ok && b.inRange(target) {
+				b.leaders[target] = true
+				worklist = append(worklist, target)
+			}
+			if isBlockTerminator(inst) {
+				if inst.Category() != "COND_BR" {
+					break
+				}
+				b.leaders[next] = true
+			}
+			addr = next
+		}
+	}
+}
+
+func (b *cfgBuilder) build() *CFG {
+	g := &CFG{
+		DecodeErrors: b.errs,
+		blockByAddr:  make(map[uint64]*BasicBlock),
+	}
+
+	for addr := range b.leaders {
+		if b.insts[addr] == nil {
+			continue
+		}
+		bb := &BasicBlock{Start: addr}
+		for {
+			inst := b.insts[addr]
+			bb.Insts = append(bb.Insts, inst)
+			addr += uint64(inst.Len())
+			if isBlockTerminator(inst) || b.leaders[addr] || b.insts[addr] == nil {
+				break
+			}
+		}
+		bb.End = addr
+		g.Blocks = append(g.Blocks, bb)
+		g.blockByAddr[bb.Start] = bb
+	}
+	sort.Slice(g.Blocks, func(i, j int) bool {
+		return g.Blocks[i].Start < g.Blocks[j].Start
+	})
+
+	link := func(kind EdgeKind, from *BasicBlock, toAddr uint64) {
+		to := g.blockByAddr[toAddr]
+		if to == nil {
+			return
+		}
+		e := &Edge{Kind: kind, From: from, To: to}
+		from.Succs = append(from.Succs, e)
+		to.Preds = append(to.Preds, e)
+	}
+
+	for _, bb := range g.Blocks {
+		addr := bb.Start
+		for _, inst := range bb.Insts {
+			if inst.Category() == "CALL" {
+				if target, ok := branchTarget(inst, addr); ok {
+					link(EdgeCall, bb, target)
+				}
+			}
+			addr += uint64(inst.Len())
+		}
+
+		last := bb.Insts[len(bb.Insts)-1]
+		lastAddr := bb.End - uint64(last.Len())
+		target, direct := branchTarget(last, lastAddr)
+		switch last.Category() {
+		case "RET":
+		case "UNCOND_BR":
+			if direct {
+				link(EdgeJump, bb, target)
+			}
+		case "COND_BR":
+			if direct {
+				link(EdgeCondTaken, bb, target)
+			}
+			link(EdgeFallthrough, bb, bb.End)
+		default:
+			link(EdgeFallthrough, bb, bb.End)
+		}
+	}
+
+	return g
+}
+
+// isBlockTerminator reports whether inst ends basic block.
+func isBlockTerminator(inst *DecodedInst) bool {
+	switch inst.Category() {
+	case "RET", "UNCOND_BR", "COND_BR":
+		return true
+	default:
+		return false
+	}
+}
+
+// branchTarget returns direct branch destination address
+// for inst that is located at addr.
+// Returns false if inst has no relative branch operand.
+func branchTarget(inst *DecodedInst, addr uint64) (uint64, bool) {
+	for _, op := range inst.Operands() {
+		if op.Kind == OperandRel {
+			next := addr + uint64(inst.Len())
+			return next + uint64(int64(op.Rel)), true
+		}
+	}
+	return 0, false
+}
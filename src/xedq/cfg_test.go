@@ -0,0 +1,108 @@
+package xedq
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBuildCFG(t *testing.T) {
+	code, _ := hex.DecodeString("" +
+		"31c0" + //       0x1000: xor eax, eax
+		"85c9" + //       0x1002: test ecx, ecx
+		"7405" + //       0x1004: jz 0x100b
+		"e805000000" + // 0x1006: call 0x1010
+		"c3" + //         0x100b: ret
+		"cccccccc" + //   0x100c: (not reachable)
+		"ffc0" + //       0x1010: inc eax
+		"83f80a" + //     0x1012: cmp eax, 10
+		"72f9" + //       0x1015: jb 0x1010
+		"c3") //          0x1017: ret
+
+	g, err := BuildCFG(NewDecoder(), code, 0x1000, 0x1000)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	edgesString := func(edges []*Edge, from bool) []string {
+		var result []string
+		for _, e := range edges {
+			bb := e.To
+			if from {
+				bb = e.From
+			}
+			result = append(result, fmt.Sprintf("%s:%#x", e.Kind, bb.Start))
+		}
+		return result
+	}
+
+	type block struct {
+		start, end uint64
+		ninsts     int
+		succs      []string
+		preds      []string
+	}
+
+	want := []block{
+		{0x1000, 0x1006, 3, []string{"cond:0x100b", "fallthrough:0x1006"}, nil},
+		{0x1006, 0x100b, 1, []string{"call:0x1010", "fallthrough:0x100b"},
+			[]string{"fallthrough:0x1000"}},
+		{0x100b, 0x100c, 1, nil,
+			[]string{"cond:0x1000", "fallthrough:0x1006"}},
+		{0x1010, 0x1017, 3, []string{"cond:0x1010", "fallthrough:0x1017"},
+			[]string{"call:0x1006", "cond:0x1010"}},
+		{0x1017, 0x1018, 1, nil, []string{"fallthrough:0x1010"}},
+	}
+
+	var have []block
+	for _, bb := range g.Blocks {
+		have = append(have, block{
+			start:  bb.Start,
+			end:    bb.End,
+			ninsts: len(bb.Insts),
+			succs:  edgesString(bb.Succs, false),
+			preds:  edgesString(bb.Preds, true),
+		})
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("blocks mismatch:\nhave: %+v\nwant: %+v", have, want)
+	}
+
+	if len(g.Entries) != 1 || g.Entries[0] != g.BlockAt(0x1000) {
+		t.Errorf("bad entries: %+v", g.Entries)
+	}
+	if g.BlockAt(0x100c) != nil {
+		t.Errorf("unreachable bytes were decoded")
+	}
+
+	if _, err := BuildCFG(NewDecoder(), code, 0x1000, 0x2000); err == nil {
+		t.Errorf("expected error for out of range entry")
+	}
+}
+
+func TestBuildCFGDecodeError(t *testing.T) {
+	code, _ := hex.DecodeString("" +
+		"e801000000" + // 0x1000: call 0x1006
+		"06" + //         0x1005: (invalid in 64bit mode)
+		"c3") //          0x1006: ret
+
+	g, err := BuildCFG(NewDecoder(), code, 0x1000, 0x1000)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	if len(g.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(g.Blocks))
+	}
+	bb := g.BlockAt(0x1000)
+	if bb == nil || bb.End != 0x1005 {
+		t.Fatalf("bad block before undecodable bytes: %+v", bb)
+	}
+	if len(bb.Succs) != 1 || bb.Succs[0].Kind != EdgeCall || bb.Succs[0].To.Start != 0x1006 {
+		t.Errorf("expected only call edge, got %+v", bb.Succs)
+	}
+	if g.DecodeErrors[0x1005] == nil || len(g.DecodeErrors) != 1 {
+		t.Errorf("expected decoding error at 0x1005, got %v", g.DecodeErrors)
+	}
+}
@@ -0,0 +1,358 @@
+package xedq
+
+import (
+	"strings"
+)
+
+// OperandKind represents decoded instruction operand class.
+type OperandKind uint8
+
+// All operand kinds.
+const (
+	// OperandOther is a kind of operands that
+	// do not fit into any other category (far pointers, for example).
+	OperandOther OperandKind = iota
+	OperandReg
+	OperandMem
+	OperandImm
+	OperandRel
+)
+
+func (kind OperandKind) String() string {
+	switch kind {
+	case OperandReg:
+		return "reg"
+	case OperandMem:
+		return "mem"
+	case OperandImm:
+		return "imm"
+	case OperandRel:
+		return "relbr"
+	default:
+		return "other"
+	}
+}
+
+// OperandVisibility describes how operand is specified in assembly syntax.
+type OperandVisibility uint8
+
+// All operand visibility classes.
+const (
+	// OperandExplicit operands are encoded in instruction
+	// and printed in assembly syntax.
+	OperandExplicit OperandVisibility = iota
+
+	// OperandImplicit operands are implied by instruction opcode,
+	// but are printed in assembly syntax (like AL in "ADD AL, 1").
+	OperandImplicit
+
+	// OperandSuppressed operands are implied by instruction opcode
+	// and are not printed in assembly syntax (like RFLAGS in "ADD AL, 1").
+	OperandSuppressed
+)
+
+func (vis OperandVisibility) String() string {
+	switch vis {
+	case OperandExplicit:
+		return "explicit"
+	case OperandImplicit:
+		return "implicit"
+	case OperandSuppressed:
+		return "suppressed"
+	default:
+		return "??"
+	}
+}
+
+// OperandAction describes how instruction accesses its operand.
+type OperandAction uint8
+
+// All operand actions.
+const (
+	ActionRead OperandAction = iota
+	ActionWrite
+	ActionReadWrite
+
+	// ActionReadCondWrite is unconditional read and conditional write.
+	ActionReadCondWrite
+
+	// ActionCondWrite is conditional write.
+	ActionCondWrite
+
+	// ActionCondReadWrite is conditional read and unconditional write.
+	ActionCondReadWrite
+
+	// ActionCondRead is conditional read.
+	ActionCondRead
+)
+
+// Reads reports whether operand may be read.
+func (act OperandAction) Reads() bool {
+	return act != ActionWrite && act != ActionCondWrite
+}
+
+// Writes reports whether operand may be written.
+func (act OperandAction) Writes() bool {
+	return act != ActionRead && act != ActionCondRead
+}
+
+func (act OperandAction) String() string {
+	switch act {
+	case ActionRead:
+		return "r"
+	case ActionWrite:
+		return "w"
+	case ActionReadWrite:
+		return "rw"
+	case ActionReadCondWrite:
+		return "rcw"
+	case ActionCondWrite:
+		return "cw"
+	case ActionCondReadWrite:
+		return "crw"
+	case ActionCondRead:
+		return "cr"
+	default:
+		return "??"
+	}
+}
+
+// Operand describes single decoded instruction argument.
+type Operand struct {
+	// Kind specifies which of the value fields are meaningful.
+	Kind OperandKind
+
+	// Name is XED operand name, like "REG0", "MEM0" or "IMM0".
+	Name string
+
+	Visibility OperandVisibility
+	Action     OperandAction
+
+	// Width is operand size in bits.
+	Width uint16
+
+	// Reg is a register name.
+	// Set only for OperandReg.
+	Reg string
+
+	// Mem is an effective address description.
+	// MemWidth is a memory operand size in bits.
+	// Set only for OperandMem.
+	Mem      Ptr
+	MemWidth uint16
+
+	// Imm is an immediate value.
+	// Signed immediates are sign-extended to 64bit.
+	// Set only for OperandImm.
+	Imm uint64
+
+	// Rel is a branch displacement.
+	// Set only for OperandRel.
+	Rel int32
+}
+
+// DecodedInst is a single decoded instruction.
+//
+// Should be created with Decoder.Decode.
+type DecodedInst struct {
+	xedd xedDecodedInst
+
+	// Instruction encoding.
+	// xedd refers to this slice memory.
+	code []byte
+}
+
+// Iclass returns instruction class name, like "ADD" or "CALL_NEAR".
+// Returned names are accepted by Encoder.Request.
+func (inst *DecodedInst) Iclass() string {
+	return inst.xedd.Iclass().String()
+}
+
+// Iform returns instruction form name, like "ADD_GPRv_GPRv_01".
+func (inst *DecodedInst) Iform() string {
+	return inst.xedd.Iform()
+}
+
+// Category returns instruction category name, like "BINARY" or "COND_BR".
+func (inst *DecodedInst) Category() string {
+	return inst.xedd.Category()
+}
+
+// Extension returns instruction ISA extension name, like "BASE" or "AVX512EVEX".
+func (inst *DecodedInst) Extension() string {
+	return inst.xedd.Extension()
+}
+
+// IsaSet returns instruction ISA set name, like "I86" or "AVX512F_512".
+// ISA set is a more fine-grained classification than ISA extension.
+func (inst *DecodedInst) IsaSet() string {
+	return inst.xedd.IsaSet()
+}
+
+// Attributes returns XED attribute names that are set for instruction,
+// like "STACKPUSH0" or "LOCKABLE".
+func (inst *DecodedInst) Attributes() []string {
+	return inst.xedd.Attributes()
+}
+
+// CPL returns the current privilege level that is required to
+// execute instruction: 0 for privileged instructions and 3 otherwise.
+func (inst *DecodedInst) CPL() int {
+	return inst.xedd.CPL()
+}
+
+// Len returns instruction encoding length in bytes.
+func (inst *DecodedInst) Len() int {
+	return len(inst.code)
+}
+
+// Bytes returns instruction encoding.
+// Returned slice should not be modified.
+func (inst *DecodedInst) Bytes() []byte {
+	return inst.code
+}
+
+// Operands returns instruction operands in the order
+// they appear in assembly syntax.
+// Suppressed operands are not included.
+func (inst *DecodedInst) Operands() []Operand {
+	n := inst.xedd.NumOperands()
+	ops := make([]Operand, 0, n)
+	for i := 0; i < n; i++ {
+		op := inst.xedd.Operand(i)
+		if op.Visibility != OperandSuppressed {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// AllOperands is like Operands, but also includes suppressed operands.
+func (inst *DecodedInst) AllOperands() []Operand {
+	n := inst.xedd.NumOperands()
+	ops := make([]Operand, n)
+	for i := range ops {
+		ops[i] = inst.xedd.Operand(i)
+	}
+	return ops
+}
+
+// String returns Intel syntax instruction representation.
+// Branch targets are printed as if instruction was located at zero address.
+// Intended for debugging and pretty-printing.
+func (inst *DecodedInst) String() string {
+	var f Formatter
+	s, err := f.Format(inst, 0)
+	if err != nil {
+		return inst.Iclass()
+	}
+	return s
+}
+
+// Flags returns instruction RFLAGS usage info.
+func (inst *DecodedInst) Flags() FlagsInfo {
+	var info FlagsInfo
+	info.read, info.written, info.undefined = inst.xedd.Flags()
+	return info
+}
+
+// ToRequest converts inst into encode request that is bound to enc.
+//
+// Encoding the result with the same machine mode is expected
+// to reproduce inst bytes, although XED may choose
+// another valid encoding for some instructions.
+func (inst *DecodedInst) ToRequest(enc *Encoder) *EncodeRequest {
+	req := &EncodeRequest{encoder: enc, iclass: inst.xedd.Iclass()}
+
+	eosz := inst.xedd.Eosz()
+	switch eosz {
+	case 8:
+		req.SetEosz8()
+	case 16:
+		req.SetEosz16()
+	case 32:
+		req.SetEosz32()
+	case 64:
+		req.SetEosz64()
+	}
+
+	// LOCK and REP prefixes are implied by iclass,
+	// but they are set to make the request self-descriptive.
+	req.prefixes = inst.xedd.HintPrefixes() | req.iclass.RepPrefix()
+	if strings.HasSuffix(req.iclass.String(), "_LOCK") {
+		req.prefixes |= prefixLock
+	}
+
+	req.zeroing, req.broadcast, req.rounding, req.sae = inst.xedd.EVEX()
+
+	// Address size matters for memory operands only.
+	// It is set explicitly to preserve it for the forms that
+	// have no registers to infer it from, like MOVS with 0x67 prefix.
+	if inst.xedd.NumMemOperands() != 0 {
+		switch inst.xedd.Easz() {
+		case 16:
+			req.SetEasz16()
+		case 32:
+			req.SetEasz32()
+		case 64:
+			req.SetEasz64()
+		}
+	}
+
+	for _, op := range inst.Operands() {
+		switch op.Kind {
+		case OperandReg:
+			req.pushReg(registerByName[op.Reg])
+		case OperandMem:
+			width := op.MemWidth
+			memIndex := 0
+			switch op.Name {
+			case "AGEN":
+				width = uint16(eosz)
+			case "MEM1":
+				memIndex = 1
+			}
+			req.Mem(width, op.Mem)
+			if memIndex == 0 {
+				req.SetDispWidth(uint8(inst.xedd.DispWidth(memIndex)))
+			}
+		case OperandImm:
+			inst.pushImm(req, op)
+		case OperandRel:
+			switch inst.xedd.RelWidth() {
+			case 8:
+				req.Rel8(int8(op.Rel))
+			case 16:
+				req.Rel16(int16(op.Rel))
+			default:
+				req.Rel32(op.Rel)
+			}
+		}
+	}
+
+	return req
+}
+
+func (inst *DecodedInst) pushImm(req *EncodeRequest, op Operand) {
+	if op.Name == "IMM1" {
+		// Second immediate is always 8bit.
+		req.Uint8(uint8(op.Imm))
+		return
+	}
+
+	width, signed := inst.xedd.ImmWidth()
+	switch {
+	case width == 8 && signed:
+		req.Int8(int8(op.Imm))
+	case width == 8:
+		req.Uint8(uint8(op.Imm))
+	case width == 16:
+		req.Int16(int16(op.Imm))
+	case width == 32 && signed:
+		req.Int32(int32(op.Imm))
+	case width == 32:
+		req.Uint32(uint32(op.Imm))
+	default:
+		req.Uint64(op.Imm)
+	}
+}
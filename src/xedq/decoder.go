@@ -0,0 +1,126 @@
+package xedq
+
+import (
+	"fmt"
+)
+
+// Decoder is x86 instructions disassembler.
+//
+// Should be created with NewDecoder.
+//
+// Not thread-safe. Create decoder per goroutine, or share with mutex.
+type Decoder struct {
+	// Scratch space for length decoding.
+	ild xedDecodedInst
+
+	mode xedState
+	chip xedChip
+}
+
+// DecoderOption is a configuration function for NewDecoder.
+type DecoderOption func(*Decoder)
+
+// DecoderMode16 sets machine mode to 16bit protected mode.
+// Predefined DecoderOption.
+func DecoderMode16(dec *Decoder) { dec.mode = newXEDState16() }
+
+// DecoderModeReal16 sets machine mode to 16bit real mode.
+// Predefined DecoderOption.
+func DecoderModeReal16(dec *Decoder) { dec.mode = newXEDStateReal16() }
+
+// DecoderMode32 sets machine mode to 32bit.
+// Predefined DecoderOption.
+func DecoderMode32(dec *Decoder) { dec.mode = newXEDState32() }
+
+// DecoderMode64 sets machine mode to 64bit.
+// Predefined DecoderOption.
+func DecoderMode64(dec *Decoder) { dec.mode = newXEDState64() }
+
+// DecoderChip restricts valid instructions set to those
+// that are supported by the named chip, like "SKYLAKE" or "I486".
+// Chip names are XED xed_chip_enum_t names without "XED_CHIP_" prefix.
+//
+// Panics if chip name is unknown.
+func DecoderChip(name string) DecoderOption {
+	var tmpbuf buffer
+	chip := xedChipInvalid
+	if len(name) < bufferCapacity {
+		chip = newXEDChip(name, &tmpbuf)
+	}
+	if chip == xedChipInvalid {
+		panic("decoder: unknown chip " + name)
+	}
+	return func(dec *Decoder) { dec.chip = chip }
+}
+
+// NewDecoder returns decoder that is configured by specified options.
+//
+// Default options:
+//   + DecoderMode64
+func NewDecoder(options ...DecoderOption) *Decoder {
+	var dec Decoder
+
+	// Set defaults.
+	dec.mode = newXEDState64()
+
+	for i := range options {
+		options[i](&dec)
+	}
+
+	return &dec
+}
+
+// Decode decodes the first instruction from code.
+// Trailing bytes that do not belong to that instruction are ignored.
+func (dec *Decoder) Decode(code []byte) (*DecodedInst, error) {
+	// XED keeps a reference to the decoded bytes,
+	// so give it a private copy that will live
+	// as long as the resulting instruction.
+	n := len(code)
+	if n > xedMaxInstBytes {
+		n = xedMaxInstBytes
+	}
+	buf := make([]byte, n)
+	copy(buf, code)
+
+	var inst DecodedInst
+	if err := xedDecode(&dec.mode, dec.chip, buf, &inst.xedd); err != nil {
+		if err == errInvalidForChip {
+			return nil, fmt.Errorf("decoder: instruction is not supported by %s chip", dec.chip)
+		}
+		return nil, err
+	}
+	inst.code = buf[:inst.xedd.Len()]
+	return &inst, nil
+}
+
+// InstLen returns the length of the first instruction in code.
+//
+// Only instruction length decoding is performed, which is
+// significantly faster than Decode. Does not allocate.
+//
+// Notice: length decoder does not validate instruction,
+// so some invalid instructions are not reported as errors.
+func (dec *Decoder) InstLen(code []byte) (int, error) {
+	return xedInstLen(&dec.mode, code, &dec.ild)
+}
+
+// Boundaries returns offsets of all instructions in code,
+// as if code was disassembled by linear sweep.
+//
+// On error, offsets of the instructions that precede
+// the failed one are returned along with the error.
+//
+// See InstLen.
+func (dec *Decoder) Boundaries(code []byte) ([]int, error) {
+	var offsets []int
+	for offset := 0; offset < len(code); {
+		n, err := dec.InstLen(code[offset:])
+		if err != nil {
+			return offsets, fmt.Errorf("decoder: offset %d: %v", offset, err)
+		}
+		offsets = append(offsets, offset)
+		offset += n
+	}
+	return offsets, nil
+}
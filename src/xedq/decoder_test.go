@@ -0,0 +1,390 @@
+package xedq
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestDecoderMode64(t *testing.T) {
+	decoder := NewDecoder(DecoderMode64)
+
+	reg := func(name, reg string) Operand {
+		return Operand{Kind: OperandReg, Name: name, Reg: reg}
+	}
+
+	tests := []struct {
+		encoding string
+		iclass   string
+		ops      []Operand
+	}{
+		{"4889c8", "MOV", []Operand{reg("REG0", "RAX"), reg("REG1", "RCX")}},
+		{"4d31c2", "XOR", []Operand{reg("REG0", "R10"), reg("REG1", "R8")}},
+		{"0433", "ADD", []Operand{
+			reg("REG0", "AL"),
+			{Kind: OperandImm, Name: "IMM0", Imm: 0x33},
+		}},
+		{"678b449144", "MOV", []Operand{
+			reg("REG0", "EAX"),
+			{Kind: OperandMem, Name: "MEM0", Mem: Ptr{"ECX", "EDX", 4, 0x44, ""}, MemWidth: 32},
+		}},
+		{"488d0409", "LEA", []Operand{
+			reg("REG0", "RAX"),
+			{Kind: OperandMem, Name: "AGEN", Mem: Ptr{"RCX", "RCX", 1, 0, ""}},
+		}},
+		{"e834120000", "CALL_NEAR", []Operand{
+			{Kind: OperandRel, Name: "RELBR", Rel: 0x1234},
+		}},
+		{"64488b042528000000", "MOV", []Operand{
+			reg("REG0", "RAX"),
+			{Kind: OperandMem, Name: "MEM0", Mem: Ptr{Disp: 0x28, Seg: "FS"}, MemWidth: 64},
+		}},
+	}
+
+	for _, test := range tests {
+		code, _ := hex.DecodeString(test.encoding)
+		// Trailing bytes should not affect the result.
+		inst, err := decoder.Decode(append(code, 0x90, 0x90))
+		if err != nil {
+			t.Errorf("%q decoding error: %v", test.encoding, err)
+			continue
+		}
+		if inst.Len() != len(code) {
+			t.Errorf("%q length mismatch:\nhave: %d\nwant: %d",
+				test.encoding, inst.Len(), len(code))
+		}
+		if inst.Iclass() != test.iclass {
+			t.Errorf("%q iclass mismatch:\nhave: %s\nwant: %s",
+				test.encoding, inst.Iclass(), test.iclass)
+		}
+		if ops := operandValues(inst.Operands()); !reflect.DeepEqual(ops, test.ops) {
+			t.Errorf("%q operands mismatch:\nhave: %+v\nwant: %+v",
+				test.encoding, ops, test.ops)
+		}
+	}
+}
+
+func TestDecoderMode16(t *testing.T) {
+	for _, mode := range []DecoderOption{DecoderMode16, DecoderModeReal16} {
+		decoder := NewDecoder(mode)
+		inst, err := decoder.Decode([]byte{0x8b, 0x41, 0x04})
+		if err != nil {
+			t.Fatalf("decoding error: %v", err)
+		}
+		want := []Operand{
+			{Kind: OperandReg, Name: "REG0", Reg: "AX"},
+			{Kind: OperandMem, Name: "MEM0", Mem: Ptr{Base: "BX", Index: "DI", Scale: 1, Disp: 4}, MemWidth: 16},
+		}
+		if ops := operandValues(inst.Operands()); !reflect.DeepEqual(ops, want) {
+			t.Errorf("operands mismatch:\nhave: %+v\nwant: %+v", ops, want)
+		}
+	}
+}
+
+func TestOperandAccess(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+	decoder := encoder.Decoder()
+
+	type access struct {
+		name  string
+		vis   OperandVisibility
+		act   OperandAction
+		width uint16
+	}
+
+	tests := []struct {
+		encoding string
+		req      *EncodeRequest
+		ops      []access
+	}{
+		{"0433", encoder.Request("ADD").Reg("AL").Uint8(0x33), []access{
+			{"REG0", OperandImplicit, ActionReadWrite, 8},
+			{"IMM0", OperandExplicit, ActionRead, 8},
+			{"REG1", OperandSuppressed, ActionWrite, 64},
+		}},
+		{"4801c8", encoder.Request("ADD").Reg("RAX").Reg("RCX"), []access{
+			{"REG0", OperandExplicit, ActionReadWrite, 64},
+			{"REG1", OperandExplicit, ActionRead, 64},
+			{"REG2", OperandSuppressed, ActionWrite, 64},
+		}},
+		{"0f44c1", encoder.Request("CMOVZ").Reg("EAX").Reg("ECX"), []access{
+			{"REG0", OperandExplicit, ActionCondWrite, 32},
+			{"REG1", OperandExplicit, ActionRead, 32},
+			{"REG2", OperandSuppressed, ActionRead, 64},
+		}},
+	}
+
+	collect := func(ops []Operand) []access {
+		var result []access
+		for _, op := range ops {
+			result = append(result, access{op.Name, op.Visibility, op.Action, op.Width})
+		}
+		return result
+	}
+
+	for _, test := range tests {
+		code, _ := hex.DecodeString(test.encoding)
+		inst, err := decoder.Decode(code)
+		if err != nil {
+			t.Errorf("%q decoding error: %v", test.encoding, err)
+			continue
+		}
+		if have := collect(inst.AllOperands()); !reflect.DeepEqual(have, test.ops) {
+			t.Errorf("%q decoded operands mismatch:\nhave: %+v\nwant: %+v",
+				test.encoding, have, test.ops)
+		}
+		have := collect(test.req.AllOperands())
+		if err := encoder.Err(); err != nil {
+			t.Errorf("%s: encoding error: %v", test.req, err)
+			continue
+		}
+		if !reflect.DeepEqual(have, test.ops) {
+			t.Errorf("%s: request operands mismatch:\nhave: %+v\nwant: %+v",
+				test.req, have, test.ops)
+		}
+	}
+}
+
+func TestInstClassification(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+	decoder := encoder.Decoder()
+
+	type classes struct {
+		category  string
+		extension string
+		isaSet    string
+		cpl       int
+	}
+
+	tests := []struct {
+		encoding string
+		req      *EncodeRequest
+		want     classes
+		attr     string
+	}{
+		{"4801c8", encoder.Request("ADD").Reg("RAX").Reg("RCX"),
+			classes{"BINARY", "BASE", "I86", 3}, ""},
+		{"7712", encoder.Request("JNBE").Rel8(0x12),
+			classes{"COND_BR", "BASE", "I86", 3}, ""},
+		{"e834120000", encoder.Request("CALL_NEAR").Rel32(0x1234),
+			classes{"CALL", "BASE", "I86", 3}, "STACKPUSH0"},
+		{"50", encoder.Request("PUSH").Reg("RAX"),
+			classes{"PUSH", "BASE", "I86", 3}, "STACKPUSH0"},
+		{"0f01f8", encoder.Request("SWAPGS"),
+			classes{"SYSTEM", "LONGMODE", "LONGMODE", 0}, ""},
+		{"62b1ad0c58c4", encoder.Request("VADDPD").Reg("XMM0").Reg("K4").Reg("XMM10").Reg("XMM20"),
+			classes{"AVX512", "AVX512EVEX", "AVX512F_128", 3}, ""},
+	}
+
+	hasAttr := func(attrs []string, attr string) bool {
+		for _, a := range attrs {
+			if a == attr {
+				return true
+			}
+		}
+		return attr == ""
+	}
+
+	for _, test := range tests {
+		code, _ := hex.DecodeString(test.encoding)
+		inst, err := decoder.Decode(code)
+		if err != nil {
+			t.Errorf("%q decoding error: %v", test.encoding, err)
+			continue
+		}
+		have := classes{inst.Category(), inst.Extension(), inst.IsaSet(), inst.CPL()}
+		if have != test.want {
+			t.Errorf("%q: decoded classes mismatch:\nhave: %+v\nwant: %+v",
+				test.encoding, have, test.want)
+		}
+		if !hasAttr(inst.Attributes(), test.attr) {
+			t.Errorf("%q: decoded attributes %v have no %s",
+				test.encoding, inst.Attributes(), test.attr)
+		}
+
+		have = classes{test.req.Category(), test.req.Extension(), test.req.IsaSet(), test.req.CPL()}
+		if err := encoder.Err(); err != nil {
+			t.Errorf("%s: encoding error: %v", test.req, err)
+			continue
+		}
+		if have != test.want {
+			t.Errorf("%s: request classes mismatch:\nhave: %+v\nwant: %+v",
+				test.req, have, test.want)
+		}
+		if !hasAttr(test.req.Attributes(), test.attr) {
+			t.Errorf("%s: request attributes %v have no %s",
+				test.req, test.req.Attributes(), test.attr)
+		}
+	}
+}
+
+func TestDecoderInstLen(t *testing.T) {
+	decoder := NewDecoder(DecoderMode64)
+
+	tests := []string{
+		"90",
+		"4889c8",
+		"0433",
+		"678b449144",
+		"4981f7f0f00000",
+		"e834120000",
+		"62b1ad0c58c4",
+		"48b88877665544332211", // MOV RAX, imm64
+	}
+
+	var code []byte
+	var wantOffsets []int
+	for _, encoding := range tests {
+		inst, _ := hex.DecodeString(encoding)
+		n, err := decoder.InstLen(append(inst, 0x90))
+		if err != nil {
+			t.Errorf("%q length decoding error: %v", encoding, err)
+			continue
+		}
+		if n != len(inst) {
+			t.Errorf("%q length mismatch:\nhave: %d\nwant: %d", encoding, n, len(inst))
+		}
+		wantOffsets = append(wantOffsets, len(code))
+		code = append(code, inst...)
+	}
+
+	offsets, err := decoder.Boundaries(code)
+	if err != nil {
+		t.Fatalf("boundaries error: %v", err)
+	}
+	if !reflect.DeepEqual(offsets, wantOffsets) {
+		t.Errorf("boundaries mismatch:\nhave: %v\nwant: %v", offsets, wantOffsets)
+	}
+
+	// Truncated instruction at the end.
+	offsets, err = decoder.Boundaries(append(code, 0x48, 0x89))
+	if err == nil {
+		t.Errorf("expected truncated instruction error")
+	}
+	if !reflect.DeepEqual(offsets, wantOffsets) {
+		t.Errorf("partial boundaries mismatch:\nhave: %v\nwant: %v", offsets, wantOffsets)
+	}
+}
+
+func TestDecoderChip(t *testing.T) {
+	tests := []struct {
+		chip     string
+		encoding string
+		ok       bool
+	}{
+		{"SKYLAKE", "c5e958c3", true},      // VADDPD (VEX)
+		{"PENTIUM", "c5e958c3", false},     // VADDPD (VEX)
+		{"SKYLAKE", "62f1ed0c58c3", false}, // VADDPD (EVEX)
+		{"SKYLAKE_SERVER", "62f1ed0c58c3", true},
+		{"PENTIUM", "89c8", true},
+	}
+
+	for _, test := range tests {
+		decoder := NewDecoder(DecoderMode32, DecoderChip(test.chip))
+		code, _ := hex.DecodeString(test.encoding)
+		if _, err := decoder.Decode(code); (err == nil) != test.ok {
+			t.Errorf("%q on %s: unexpected error state: %v", test.encoding, test.chip, err)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	decoder := NewDecoder()
+
+	tests := []string{
+		"",     // Empty input
+		"0f",   // Truncated opcode
+		"4889", // Truncated ModRM
+		"06",   // PUSH ES is invalid in 64bit mode
+	}
+
+	for _, encoding := range tests {
+		code, _ := hex.DecodeString(encoding)
+		if _, err := decoder.Decode(code); err == nil {
+			t.Errorf("%q: expected decoding error", encoding)
+		}
+	}
+}
+
+func TestDecodedInstToRequest(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+	decoder := encoder.Decoder()
+
+	tests := []string{
+		"4889c8",
+		"4d31c2",
+		"0433",
+		"83c077",
+		"0511223344",
+		"4981f7f0f00000",
+		"678b449144",
+		"488b04c8",
+		"488d0409",
+		"66678d400f",
+		"666781003333",
+		"e834120000",
+		"7712",
+	}
+
+	for _, encoding := range tests {
+		code, _ := hex.DecodeString(encoding)
+		inst, err := decoder.Decode(code)
+		if err != nil {
+			t.Errorf("%q decoding error: %v", encoding, err)
+			continue
+		}
+		req := inst.ToRequest(encoder)
+		have := req.EncodeHexString()
+		if err := encoder.Err(); err != nil {
+			t.Errorf("%q encoding error:\n%s\n%v", encoding, req, err)
+			continue
+		}
+		if have != encoding {
+			t.Errorf("round trip mismatch:\n%s\nhave: %q\nwant: %q",
+				req, have, encoding)
+		}
+	}
+}
+
+// operandValues clears operand access info, leaving only operand values.
+// Access info is covered by TestOperandAccess.
+func operandValues(ops []Operand) []Operand {
+	for i := range ops {
+		ops[i].Visibility = OperandExplicit
+		ops[i].Action = ActionRead
+		ops[i].Width = 0
+	}
+	return ops
+}
+
+func BenchmarkDecoderSweep(b *testing.B) {
+	code, _ := hex.DecodeString(
+		"4889c8" + "0433" + "678b449144" + "4981f7f0f00000" + "e834120000" + "62b1ad0c58c4")
+	code = bytes.Repeat(code, 64)
+	decoder := NewDecoder()
+
+	b.Run("Decode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for offset := 0; offset < len(code); {
+				inst, err := decoder.Decode(code[offset:])
+				if err != nil {
+					b.Fatal(err)
+				}
+				offset += inst.Len()
+			}
+		}
+	})
+
+	b.Run("InstLen", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for offset := 0; offset < len(code); {
+				n, err := decoder.InstLen(code[offset:])
+				if err != nil {
+					b.Fatal(err)
+				}
+				offset += n
+			}
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package xedq
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const (
+	// Disassembler read buffer size.
+	// Should be at least xedMaxInstBytes.
+	disasmBufferSize = 4096
+
+	// How many empty reads in a row are tolerated
+	// before giving up with io.ErrNoProgress.
+	maxEmptyReads = 100
+)
+
+// ResyncPolicy describes how Disassembler handles bytes
+// that can not be decoded as an instruction.
+type ResyncPolicy uint8
+
+// All resync policies.
+const (
+	// ResyncStop makes disassembler stop at the first invalid instruction.
+	// Disassembler.Err reports decoding error.
+	ResyncStop ResyncPolicy = iota
+
+	// ResyncSkipByte makes disassembler report an invalid byte
+	// as a single-byte entry and continue from the next byte.
+	// Such entries have nil Disassembler.Inst and
+	// non-nil Disassembler.DecodeErr.
+	ResyncSkipByte
+)
+
+// Disassembler is a linear-sweep disassembler.
+//
+// Should be created with NewDisassembler or NewBytesDisassembler.
+//
+// Interface is modeled after bufio.Scanner:
+//   for d.Scan() {
+//       fmt.Printf("%#x: %s\n", d.Addr(), d.Inst())
+//   }
+//   if err := d.Err(); err != nil {
+//       // Handle error.
+//   }
+type Disassembler struct {
+	// Resync specifies invalid instruction bytes handling.
+	// Can be changed between Scan calls.
+	Resync ResyncPolicy
+
+	dec *Decoder
+	r   io.Reader
+	eof bool
+	err error
+
+	// Pending bytes are buf[start:end].
+	buf   []byte
+	start int
+	end   int
+
+	// Address of the next instruction.
+	addr uint64
+
+	// Current entry.
+	instAddr  uint64
+	instBytes []byte
+	inst      *DecodedInst
+	instErr   error
+}
+
+// NewDisassembler returns disassembler that decodes instructions from r.
+// addr is an address of the first instruction.
+//
+// Machine mode is taken from dec.
+func NewDisassembler(dec *Decoder, r io.Reader, addr uint64) *Disassembler {
+	return &Disassembler{
+		dec:  dec,
+		r:    r,
+		addr: addr,
+		buf:  make([]byte, disasmBufferSize),
+	}
+}
+
+// NewBytesDisassembler is like NewDisassembler, but reads from code.
+func NewBytesDisassembler(dec *Decoder, code []byte, addr uint64) *Disassembler {
+	return NewDisassembler(dec, bytes.NewReader(code), addr)
+}
+
+// Scan advances disassembler to the next entry, which will then
+// be available through Addr, Bytes, Inst and DecodeErr methods.
+// Returns false when the scan stops, either by reaching the end of
+// the input or an error.
+func (d *Disassembler) Scan() bool {
+	if d.err != nil {
+		return false
+	}
+	d.fill()
+	if d.err != nil || d.start == d.end {
+		return false
+	}
+
+	code := d.buf[d.start:d.end]
+	inst, err := d.dec.Decode(code)
+	if err != nil {
+		if d.Resync == ResyncStop {
+			d.err = fmt.Errorf("disassembler: %#x: %v", d.addr, err)
+			return false
+		}
+		d.setEntry(nil, err, []byte{code[0]})
+		return true
+	}
+	d.setEntry(inst, nil, inst.Bytes())
+	return true
+}
+
+// Addr returns the current entry address.
+func (d *Disassembler) Addr() uint64 { return d.instAddr }
+
+// Bytes returns the current entry bytes.
+// Returned slice should not be modified.
+func (d *Disassembler) Bytes() []byte { return d.instBytes }
+
+// Inst returns the current entry decoded instruction.
+// Returns nil for invalid instruction bytes.
+func (d *Disassembler) Inst() *DecodedInst { return d.inst }
+
+// DecodeErr returns the current entry decoding error.
+// Only possible when Resync is not ResyncStop.
+func (d *Disassembler) DecodeErr() error { return d.instErr }
+
+// Err returns the first non-EOF error that was encountered by the disassembler.
+func (d *Disassembler) Err() error { return d.err }
+
+func (d *Disassembler) setEntry(inst *DecodedInst, err error, code []byte) {
+	d.inst = inst
+	d.instErr = err
+	d.instBytes = code
+	d.instAddr = d.addr
+	d.addr += uint64(len(code))
+	d.start += len(code)
+}
+
+// fill reads more data into the buffer unless it already
+// contains enough bytes to decode any instruction.
+func (d *Disassembler) fill() {
+	if d.eof || d.end-d.start >= xedMaxInstBytes {
+		return
+	}
+
+	copy(d.buf, d.buf[d.start:d.end])
+	d.end -= d.start
+	d.start = 0
+
+	for emptyReads := 0; d.end < xedMaxInstBytes; {
+		n, err := d.r.Read(d.buf[d.end:])
+		d.end += n
+		switch {
+		case err == io.EOF:
+			d.eof = true
+			return
+		case err != nil:
+			d.err = err
+			return
+		case n == 0:
+			emptyReads++
+			if emptyReads == maxEmptyReads {
+				d.err = io.ErrNoProgress
+				return
+			}
+		}
+	}
+}
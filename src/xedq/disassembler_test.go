@@ -0,0 +1,71 @@
+package xedq
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDisassembler(t *testing.T) {
+	// MOV RAX, RCX; (invalid) PUSH ES; ADD AL, 0x33; truncated MOV.
+	code, _ := hex.DecodeString("4889c8" + "06" + "0433" + "4889")
+
+	type entry struct {
+		addr    uint64
+		bytes   string
+		iclass  string
+		invalid bool
+	}
+
+	tests := []struct {
+		resync  ResyncPolicy
+		entries []entry
+		fail    bool
+	}{
+		{ResyncStop, []entry{
+			{0x1000, "4889c8", "MOV", false},
+		}, true},
+		{ResyncSkipByte, []entry{
+			{0x1000, "4889c8", "MOV", false},
+			{0x1003, "06", "", true},
+			{0x1004, "0433", "ADD", false},
+			{0x1006, "48", "", true},
+			{0x1007, "89", "", true},
+		}, false},
+	}
+
+	for _, test := range tests {
+		r := iotest.OneByteReader(bytes.NewReader(code))
+		d := NewDisassembler(NewDecoder(), r, 0x1000)
+		d.Resync = test.resync
+
+		var entries []entry
+		for d.Scan() {
+			e := entry{
+				addr:    d.Addr(),
+				bytes:   hex.EncodeToString(d.Bytes()),
+				invalid: d.DecodeErr() != nil,
+			}
+			if inst := d.Inst(); inst != nil {
+				e.iclass = inst.Iclass()
+			}
+			entries = append(entries, e)
+		}
+
+		if (d.Err() != nil) != test.fail {
+			t.Errorf("resync=%d: unexpected error state: %v", test.resync, d.Err())
+		}
+		if len(entries) != len(test.entries) {
+			t.Errorf("resync=%d: entries count mismatch:\nhave: %+v\nwant: %+v",
+				test.resync, entries, test.entries)
+			continue
+		}
+		for i := range entries {
+			if entries[i] != test.entries[i] {
+				t.Errorf("resync=%d: entry %d mismatch:\nhave: %+v\nwant: %+v",
+					test.resync, i, entries[i], test.entries[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package xedq
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ELFSymbol describes ELF symbol address range.
+type ELFSymbol struct {
+	Name string
+	Addr uint64
+	Size uint64
+}
+
+// ELFFile provides disassembly helpers for x86 ELF binaries.
+//
+// Implements Symbolizer interface,
+// so it can be used with Formatter to print symbol names.
+//
+// Should be created with OpenELF or NewELFFile.
+type ELFFile struct {
+	file *elf.File
+
+	// Whether file is owned by ELFFile and should be closed.
+	owned bool
+
+	dec *Decoder
+
+	// Function and object symbols sorted by address.
+	symbols []ELFSymbol
+
+	sectionData map[*elf.Section][]byte
+}
+
+// OpenELF opens the named file using elf.Open and prepares it for disassembly.
+// Resulting ELFFile should be closed after use.
+func OpenELF(name string) (*ELFFile, error) {
+	file, err := elf.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewELFFile(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.owned = true
+	return f, nil
+}
+
+// NewELFFile prepares already opened ELF file for disassembly.
+// Decoder machine mode is selected based on the file class.
+func NewELFFile(file *elf.File) (*ELFFile, error) {
+	var mode DecoderOption
+	switch {
+	case file.Class == elf.ELFCLASS64 && file.Machine == elf.EM_X86_64:
+		mode = DecoderMode64
+	case file.Class == elf.ELFCLASS32 && file.Machine == elf.EM_386:
+		mode = DecoderMode32
+	default:
+		return nil, fmt.Errorf("elf: unsupported %s %s binary", file.Class, file.Machine)
+	}
+
+	f := &ELFFile{
+		file:        file,
+		dec:         NewDecoder(mode),
+		sectionData: make(map[*elf.Section][]byte),
+	}
+	if err := f.loadSymbols(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close closes the underlying ELF file if it was opened by OpenELF.
+func (f *ELFFile) Close() error {
+	if f.owned {
+		return f.file.Close()
+	}
+	return nil
+}
+
+// Decoder returns decoder that is configured for the file machine mode.
+func (f *ELFFile) Decoder() *Decoder { return f.dec }
+
+// Symbols returns function and object symbols sorted by address.
+// Returned slice should not be modified.
+func (f *ELFFile) Symbols() []ELFSymbol { return f.symbols }
+
+// ExecSections returns all executable sections that have file data.
+func (f *ELFFile) ExecSections() []*elf.Section {
+	var sections []*elf.Section
+	for _, sec := range f.file.Sections {
+		if sec.Type == elf.SHT_PROGBITS && sec.Flags&elf.SHF_EXECINSTR != 0 {
+			sections = append(sections, sec)
+		}
+	}
+	return sections
+}
+
+// Symbolize implements Symbolizer interface using file symbol table.
+func (f *ELFFile) Symbolize(addr uint64) (string, uint64, bool) {
+	i := sort.Search(len(f.symbols), func(i int) bool {
+		return f.symbols[i].Addr > addr
+	})
+	if i == 0 {
+		return "", 0, false
+	}
+	sym := f.symbols[i-1]
+	offset := addr - sym.Addr
+	if offset != 0 && offset >= sym.Size {
+		return "", 0, false
+	}
+	return sym.Name, offset, true
+}
+
+// Function returns code of the named function and its address.
+func (f *ELFFile) Function(name string) ([]byte, uint64, error) {
+	for _, sym := range f.symbols {
+		if sym.Name != name {
+			continue
+		}
+		sec := f.sectionAt(sym.Addr)
+		if sec == nil {
+			return nil, 0, fmt.Errorf("elf: %s: no executable section at %#x", name, sym.Addr)
+		}
+		data, err := f.data(sec)
+		if err != nil {
+			return nil, 0, err
+		}
+		start := sym.Addr - sec.Addr
+		end := start + sym.Size
+		if end > uint64(len(data)) {
+			end = uint64(len(data))
+		}
+		return data[start:end], sym.Addr, nil
+	}
+	return nil, 0, fmt.Errorf("elf: function %s not found", name)
+}
+
+// DisassembleFunction returns disassembler for the named function.
+func (f *ELFFile) DisassembleFunction(name string) (*Disassembler, error) {
+	code, addr, err := f.Function(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewBytesDisassembler(f.dec, code, addr), nil
+}
+
+// DisassembleSection returns disassembler for the named section.
+// Empty name means ".text".
+func (f *ELFFile) DisassembleSection(name string) (*Disassembler, error) {
+	if name == "" {
+		name = ".text"
+	}
+	sec := f.file.Section(name)
+	if sec == nil {
+		return nil, fmt.Errorf("elf: section %s not found", name)
+	}
+	if sec.Flags&elf.SHF_EXECINSTR == 0 {
+		return nil, fmt.Errorf("elf: section %s is not executable", name)
+	}
+	data, err := f.data(sec)
+	if err != nil {
+		return nil, err
+	}
+	return NewBytesDisassembler(f.dec, data, sec.Addr), nil
+}
+
+func (f *ELFFile) loadSymbols() error {
+	syms, err := f.file.Symbols()
+	if errors.Is(err, elf.ErrNoSymbols) {
+		syms, err = f.file.DynamicSymbols()
+	}
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return err
+	}
+
+	for _, sym := range syms {
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_FUNC, elf.STT_OBJECT:
+		default:
+			continue
+		}
+		if sym.Value == 0 || sym.Section == elf.SHN_UNDEF {
+			continue
+		}
+		f.symbols = append(f.symbols, ELFSymbol{
+			Name: sym.Name,
+			Addr: sym.Value,
+			Size: sym.Size,
+		})
+	}
+	sort.SliceStable(f.symbols, func(i, j int) bool {
+		return f.symbols[i].Addr < f.symbols[j].Addr
+	})
+	return nil
+}
+
+// sectionAt returns executable section that contains addr.
+func (f *ELFFile) sectionAt(addr uint64) *elf.Section {
+	for _, sec := range f.ExecSections() {
+		if addr >= sec.Addr && addr-sec.Addr < sec.Size {
+			return sec
+		}
+	}
+	return nil
+}
+
+func (f *ELFFile) data(sec *elf.Section) ([]byte, error) {
+	if data, ok := f.sectionData[sec]; ok {
+		return data, nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil, fmt.Errorf("elf: %s: %v", sec.Name, err)
+	}
+	f.sectionData[sec] = data
+	return data, nil
+}
@@ -0,0 +1,66 @@
+package xedq
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestELFFile(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("test binary is not x86-64 ELF")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("can't locate test binary: %v", err)
+	}
+	f, err := OpenELF(exe)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer f.Close()
+
+	// Package import path is not known, so match by suffix.
+	var fn ELFSymbol
+	for _, sym := range f.Symbols() {
+		if strings.HasSuffix(sym.Name, "xedq.TestELFFile") {
+			fn = sym
+		}
+	}
+	if fn.Name == "" {
+		t.Skip("test binary has no symbol table")
+	}
+
+	name, offset, ok := f.Symbolize(fn.Addr + 1)
+	if !ok || name != fn.Name || offset != 1 {
+		t.Errorf("Symbolize(%#x): have (%q, %d, %v), want (%q, 1, true)",
+			fn.Addr+1, name, offset, ok, fn.Name)
+	}
+
+	d, err := f.DisassembleFunction(fn.Name)
+	if err != nil {
+		t.Fatalf("disassemble error: %v", err)
+	}
+	addr := fn.Addr
+	for d.Scan() {
+		if d.Addr() != addr {
+			t.Fatalf("address mismatch: have %#x, want %#x", d.Addr(), addr)
+		}
+		addr += uint64(len(d.Bytes()))
+	}
+	if err := d.Err(); err != nil {
+		t.Errorf("disassemble error: %v", err)
+	}
+	if addr != fn.Addr+fn.Size {
+		t.Errorf("function was not fully disassembled: stopped at %#x", addr)
+	}
+
+	if _, err := f.DisassembleSection(""); err != nil {
+		t.Errorf(".text disassemble error: %v", err)
+	}
+	if _, err := f.DisassembleSection(".data"); err == nil {
+		t.Errorf("expected error for non-executable section")
+	}
+}
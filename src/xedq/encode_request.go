@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"strings"
 )
 
@@ -16,16 +17,28 @@ const (
 	argReg
 	argMem
 	argUint8
+	argUint16
 	argUint32
 	argUint64
 	argInt8
 	argInt16
 	argInt32
+	argInt64
 	argRel8
 	argRel16
 	argRel32
 )
 
+// isImm reports whether tag is an immediate argument class.
+func (tag argTag) isImm() bool {
+	switch tag {
+	case argUint8, argUint16, argUint32, argUint64, argInt8, argInt16, argInt32, argInt64:
+		return true
+	default:
+		return false
+	}
+}
+
 // effectiveOperandSize is XED's EOSZ attribute.
 // Specifies instruction data size.
 type effectiveOperandSize uint8
@@ -54,6 +67,102 @@ func (eosz effectiveOperandSize) String() string {
 	}
 }
 
+// effectiveAddressSize is XED's EASZ attribute.
+type effectiveAddressSize uint8
+
+// All valid effective address sizes.
+const (
+	easzDefault effectiveAddressSize = iota
+	easz16
+	easz32
+	easz64
+)
+
+// Width returns easz in bits, or 0 for easzDefault.
+func (easz effectiveAddressSize) Width() int {
+	switch easz {
+	case easz16:
+		return 16
+	case easz32:
+		return 32
+	case easz64:
+		return 64
+	default:
+		return 0
+	}
+}
+
+// legacyPrefixes is a set of optional legacy prefixes.
+type legacyPrefixes uint8
+
+// All supported legacy prefixes.
+const (
+	prefixLock legacyPrefixes = 1 << iota
+	prefixRep
+	prefixRepne
+	prefixXacquire
+	prefixXrelease
+	prefixHintTaken
+	prefixHintNotTaken
+)
+
+// prefixesHint is a set of prefixes that are not checked
+// by XED encoder, so they are verified by decoding the result.
+const prefixesHint = prefixXacquire | prefixXrelease | prefixHintTaken | prefixHintNotTaken
+
+// legacyPrefixNames lists prefixes in order they are printed.
+var legacyPrefixNames = []struct {
+	prefix legacyPrefixes
+	name   string
+}{
+	{prefixXacquire, "XACQUIRE"},
+	{prefixXrelease, "XRELEASE"},
+	{prefixLock, "LOCK"},
+	{prefixRep, "REP"},
+	{prefixRepne, "REPNE"},
+	{prefixHintTaken, "HINT_TAKEN"},
+	{prefixHintNotTaken, "HINT_NOT_TAKEN"},
+}
+
+// String returns space-separated prefix names.
+func (prefixes legacyPrefixes) String() string {
+	var names []string
+	for _, p := range legacyPrefixNames {
+		if prefixes&p.prefix != 0 {
+			names = append(names, p.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// roundingMode is AVX-512 embedded rounding control.
+// Values match XED ROUNDC operand.
+type roundingMode uint8
+
+// All rounding modes.
+const (
+	roundingDefault roundingMode = iota
+	roundingRN
+	roundingRD
+	roundingRU
+	roundingRZ
+)
+
+func (rc roundingMode) String() string {
+	switch rc {
+	case roundingRN:
+		return "rn-sae"
+	case roundingRD:
+		return "rd-sae"
+	case roundingRU:
+		return "ru-sae"
+	case roundingRZ:
+		return "rz-sae"
+	default:
+		return ""
+	}
+}
+
 // EncodeRequest is an instruction builder.
 //
 // Methods that have no "Set" prefix push argument
@@ -67,14 +176,16 @@ type EncodeRequest struct {
 	encoder *Encoder // Encoder that spawned this EncodeRequest
 	iclass  xedIclass
 
-	// Immediate operand payload.
-	// TODO: add second immediate field?
-	imm uint64
+	// Immediate operands payload.
+	// imm1 is only used by instructions with two immediates, like ENTER.
+	imm  uint64
+	imm1 uint64
 
 	rel int32
 
-	ptr      Ptr
-	memWidth uint16
+	// Memory operands payload, in order of appearance.
+	// Second memory operand is used by instructions like MOVS and CMPS.
+	mems [maxMemArgs]memArg
 
 	// Holds each argument type.
 	tags [maxArgLimit]argTag
@@ -83,9 +194,24 @@ type EncodeRequest struct {
 	argc uint8
 
 	eosz effectiveOperandSize
+	easz effectiveAddressSize
+
+	prefixes legacyPrefixes
+
+	// Encoding form preferences, see SetPreferEVEX and friends.
+	forms encodingForms
+
+	// AVX-512 (EVEX) modifiers.
+	zeroing   bool
+	broadcast bool
+	sae       bool
+	rounding  roundingMode
 
 	dispWidth int
 
+	// Instruction address, used to resolve MemRIP targets.
+	addr uint64
+
 	// Register arguments.
 	regs [maxArgLimit]xedRegister
 }
@@ -108,10 +234,15 @@ func (req *EncodeRequest) Reg(regName string) *EncodeRequest {
 //   128 | XMMWORD PTR
 //   256 | YMMWORD PTR
 //   512 | ZMMWORD PTR
+//
+// Up to two memory arguments are permitted.
+// The second one can only have a Base register,
+// like DS:[RSI] for MOVS and CMPS.
 func (req *EncodeRequest) Mem(width uint16, ptr Ptr) *EncodeRequest {
+	if n := req.memCount(); n < maxMemArgs {
+		req.mems[n] = memArg{ptr: ptr, width: width}
+	}
 	req.pushTag(argMem)
-	req.ptr = ptr
-	req.memWidth = width
 	return req
 }
 
@@ -127,48 +258,79 @@ func (req *EncodeRequest) MemExpr(width uint16, expr string) *EncodeRequest {
 	return req.Mem(width, ptr)
 }
 
+// MemRIP pushes RIP-relative memory indirect to arguments list.
+// Unlike Mem with RIP base, it accepts absolute target address.
+// Displacement is computed from the instruction address
+// (see SetAddr) and its encoded length.
+//
+// Only valid in 64bit mode and only as the first memory argument.
+func (req *EncodeRequest) MemRIP(width uint16, target uint64) *EncodeRequest {
+	req.Mem(width, Ptr{Base: "RIP"})
+	if n := req.memCount(); n <= maxMemArgs {
+		req.mems[n-1].target = target
+		req.mems[n-1].relative = true
+	}
+	return req
+}
+
 // Uint8 pushes 8bit unsigned immediate to argument list.
-// Notice: current implementation is limited to single immediate, so
-// instructions like ENTER are not encodable yet.
+//
+// Up to two immediates can be pushed.
+// Second immediate must be 8bit (Uint8 or Int8),
+// as in "ENTER imm16, imm8" or "EXTRQ xmm, imm8, imm8".
 func (req *EncodeRequest) Uint8(v uint8) *EncodeRequest {
-	req.imm = uint64(v)
-	req.pushTag(argUint8)
+	req.pushImm(argUint8, uint64(v))
+	return req
+}
+
+// Uint16 pushes 16bit unsigned immediate to argument list.
+// See Uint8 for immediates count limitations.
+func (req *EncodeRequest) Uint16(v uint16) *EncodeRequest {
+	req.pushImm(argUint16, uint64(v))
 	return req
 }
 
 // Uint32 pushes 32bit unsigned immediate to argument list.
-// Notice: current implementation is limited to single immediate, so
-// instructions like ENTER are not encodable yet.
+// See Uint8 for immediates count limitations.
 func (req *EncodeRequest) Uint32(v uint32) *EncodeRequest {
-	req.imm = uint64(v)
-	req.pushTag(argUint32)
+	req.pushImm(argUint32, uint64(v))
+	return req
+}
+
+// Uint64 pushes 64bit unsigned immediate to argument list.
+// Only few instructions accept it, like "MOV r64, imm64".
+// See Uint8 for immediates count limitations.
+func (req *EncodeRequest) Uint64(v uint64) *EncodeRequest {
+	req.pushImm(argUint64, v)
 	return req
 }
 
 // Int8 pushes 8bit signed immediate to argument list.
-// Notice: current implementation is limited to single immediate, so
-// instructions like ENTER are not encodable yet.
+// See Uint8 for immediates count limitations.
 func (req *EncodeRequest) Int8(v int8) *EncodeRequest {
-	req.imm = uint64(v)
-	req.pushTag(argInt8)
+	req.pushImm(argInt8, uint64(v))
 	return req
 }
 
 // Int16 pushes 16bit signed immediate to argument list.
-// Notice: current implementation is limited to single immediate, so
-// instructions like ENTER are not encodable yet.
+// See Uint8 for immediates count limitations.
 func (req *EncodeRequest) Int16(v int16) *EncodeRequest {
-	req.imm = uint64(v)
-	req.pushTag(argInt16)
+	req.pushImm(argInt16, uint64(v))
 	return req
 }
 
 // Int32 pushes 32bit signed immediate to argument list.
-// Notice: current implementation is limited to single immediate, so
-// instructions like ENTER are not encodable yet.
+// See Uint8 for immediates count limitations.
 func (req *EncodeRequest) Int32(v int32) *EncodeRequest {
-	req.imm = uint64(v)
-	req.pushTag(argInt32)
+	req.pushImm(argInt32, uint64(v))
+	return req
+}
+
+// Int64 pushes 64bit signed immediate to argument list.
+// Only few instructions accept it, like "MOV r64, imm64".
+// See Uint8 for immediates count limitations.
+func (req *EncodeRequest) Int64(v int64) *EncodeRequest {
+	req.pushImm(argInt64, uint64(v))
 	return req
 }
 
@@ -217,20 +379,212 @@ func (req *EncodeRequest) SetEosz64() *EncodeRequest {
 	return req
 }
 
+// SetEasz16 sets instruction effective address size to 16bit.
+//
+// Address size is inferred from memory arguments registers,
+// so it only needs to be set for memory arguments without them,
+// like displacement-only Ptr or implicit string instruction operands.
+// It is an error to set address size that does not match
+// memory arguments registers.
+func (req *EncodeRequest) SetEasz16() *EncodeRequest {
+	req.easz = easz16
+	return req
+}
+
+// SetEasz32 sets instruction effective address size to 32bit.
+// See SetEasz16.
+func (req *EncodeRequest) SetEasz32() *EncodeRequest {
+	req.easz = easz32
+	return req
+}
+
+// SetEasz64 sets instruction effective address size to 64bit.
+// See SetEasz16.
+func (req *EncodeRequest) SetEasz64() *EncodeRequest {
+	req.easz = easz64
+	return req
+}
+
+// SetAddr sets instruction address that is used to compute
+// MemRIP displacement. Default address is 0.
+func (req *EncodeRequest) SetAddr(addr uint64) *EncodeRequest {
+	req.addr = addr
+	return req
+}
+
+// SetLock adds LOCK prefix.
+// Instruction is encoded using its XED "_LOCK" iclass,
+// so "CMPXCHG" with LOCK becomes "CMPXCHG_LOCK".
+func (req *EncodeRequest) SetLock() *EncodeRequest {
+	req.prefixes |= prefixLock
+	return req
+}
+
+// SetRep adds REP prefix.
+// For CMPS and SCAS it is a REPE prefix.
+// Instruction is encoded using its XED REP iclass,
+// so "MOVSB" with REP becomes "REP_MOVSB".
+func (req *EncodeRequest) SetRep() *EncodeRequest {
+	req.prefixes |= prefixRep
+	return req
+}
+
+// SetRepne adds REPNE prefix. See SetRep.
+func (req *EncodeRequest) SetRepne() *EncodeRequest {
+	req.prefixes |= prefixRepne
+	return req
+}
+
+// SetXacquire adds XACQUIRE (HLE) prefix.
+// Usually requires LOCK prefix, see SetLock.
+func (req *EncodeRequest) SetXacquire() *EncodeRequest {
+	req.prefixes |= prefixXacquire
+	return req
+}
+
+// SetXrelease adds XRELEASE (HLE) prefix.
+// Usually requires LOCK prefix, see SetLock.
+func (req *EncodeRequest) SetXrelease() *EncodeRequest {
+	req.prefixes |= prefixXrelease
+	return req
+}
+
+// SetHintTaken adds branch taken hint prefix (0x3E).
+// Only valid for conditional branches.
+func (req *EncodeRequest) SetHintTaken() *EncodeRequest {
+	req.prefixes |= prefixHintTaken
+	return req
+}
+
+// SetHintNotTaken adds branch not taken hint prefix (0x2E).
+// Only valid for conditional branches.
+func (req *EncodeRequest) SetHintNotTaken() *EncodeRequest {
+	req.prefixes |= prefixHintNotTaken
+	return req
+}
+
+// SetZeroing enables AVX-512 zeroing-masking ({z}).
+// Mask register is passed as a Reg argument, like K1.
+func (req *EncodeRequest) SetZeroing() *EncodeRequest {
+	req.zeroing = true
+	return req
+}
+
+// SetBroadcast enables AVX-512 memory broadcast ({1toN}).
+// Memory argument width should be the broadcasted element size,
+// like 32 for {1to16} with ZMM and packed single elements.
+func (req *EncodeRequest) SetBroadcast() *EncodeRequest {
+	req.broadcast = true
+	return req
+}
+
+// SetRoundingRN sets AVX-512 embedded rounding
+// to nearest ({rn-sae}).
+func (req *EncodeRequest) SetRoundingRN() *EncodeRequest {
+	req.rounding = roundingRN
+	return req
+}
+
+// SetRoundingRD sets AVX-512 embedded rounding
+// down, toward -inf ({rd-sae}).
+func (req *EncodeRequest) SetRoundingRD() *EncodeRequest {
+	req.rounding = roundingRD
+	return req
+}
+
+// SetRoundingRU sets AVX-512 embedded rounding
+// up, toward +inf ({ru-sae}).
+func (req *EncodeRequest) SetRoundingRU() *EncodeRequest {
+	req.rounding = roundingRU
+	return req
+}
+
+// SetRoundingRZ sets AVX-512 embedded rounding
+// toward zero ({rz-sae}).
+func (req *EncodeRequest) SetRoundingRZ() *EncodeRequest {
+	req.rounding = roundingRZ
+	return req
+}
+
+// SetSAE enables AVX-512 suppress all exceptions ({sae}).
+// Embedded rounding implies SAE, so there is no need
+// to call it along with SetRounding methods.
+func (req *EncodeRequest) SetSAE() *EncodeRequest {
+	req.sae = true
+	return req
+}
+
+// SetPreferEVEX makes encoder use EVEX encoding for
+// instructions that have both VEX and EVEX forms.
+// Ignored for instructions without EVEX form,
+// unless encoder uses EncoderStrictForms.
+func (req *EncodeRequest) SetPreferEVEX() *EncodeRequest {
+	req.forms |= formEVEX
+	return req
+}
+
+// SetPreferREX makes encoder emit REX prefix even if it is redundant.
+// Ignored if REX can't be used, like outside of 64bit mode,
+// unless encoder uses EncoderStrictForms.
+func (req *EncodeRequest) SetPreferREX() *EncodeRequest {
+	req.forms |= formREX
+	return req
+}
+
+// SetPreferVEX3 makes encoder use 3-byte VEX prefix (0xC4),
+// even if 2-byte VEX prefix (0xC5) is sufficient.
+// Ignored for non-VEX instructions,
+// unless encoder uses EncoderStrictForms.
+// Applied by rewriting encoded bytes, see encodePreferredForm.
+func (req *EncodeRequest) SetPreferVEX3() *EncodeRequest {
+	req.forms |= formVEX3
+	return req
+}
+
+// SetPreferStoreForm makes encoder use MR ("store") opcode
+// direction for register-register forms that have both,
+// like "89 /r" for "MOV r32, r32".
+// Ignored for instructions without such forms,
+// unless encoder uses EncoderStrictForms.
+// Applied by rewriting encoded bytes, see encodePreferredForm.
+func (req *EncodeRequest) SetPreferStoreForm() *EncodeRequest {
+	req.forms = req.forms&^formLoad | formStore
+	return req
+}
+
+// SetPreferLoadForm makes encoder use RM ("load") opcode
+// direction for register-register forms that have both,
+// like "8B /r" for "MOV r32, r32".
+// Ignored for instructions without such forms,
+// unless encoder uses EncoderStrictForms.
+// Applied by rewriting encoded bytes, see encodePreferredForm.
+func (req *EncodeRequest) SetPreferLoadForm() *EncodeRequest {
+	req.forms = req.forms&^formStore | formLoad
+	return req
+}
+
 // SetDispWidth changes displacement encoding strategy.
 //
 // width values:
 //   0  - use smallest displacement size
-//   8  - 8bit displacement
+//   8  - 8bit displacement (disp8*N for EVEX instructions)
+//   16 - 16bit displacement (16bit addressing only)
 //   32 - 32bit displacement
+//   64 - 64bit displacement (MOV moffs forms only)
 // All other values are treated as 0.
-func (req *EncodeRequest) SetDispWidth(width uint8) {
+//
+// EVEX instructions scale 8bit displacement by N that depends
+// on the memory operand, so displacement of 0x40 for 512bit
+// operand is encoded as disp8 of 1. If displacement can't be
+// encoded with specified width, encoding fails.
+func (req *EncodeRequest) SetDispWidth(width uint8) *EncodeRequest {
 	switch width {
-	case 8, 32:
+	case 8, 16, 32, 64:
 		req.dispWidth = int(width)
 	default:
 		req.dispWidth = 0
 	}
+	return req
 }
 
 // Encode executes encode request and returns result "as it".
@@ -238,6 +592,17 @@ func (req *EncodeRequest) Encode() []byte {
 	return req.encoder.encode(req)
 }
 
+// EncodeAll executes encode request and returns all its valid encodings.
+//
+// Encodings differ in opcode direction, displacement width,
+// VEX/EVEX forms and redundant REX prefix.
+// Encoding form preferences and displacement width of the request
+// are ignored. The first encoding is the one Encode would return
+// without them.
+func (req *EncodeRequest) EncodeAll() []Encoding {
+	return req.encoder.encodeAll(req)
+}
+
 // EncodeTo is like Encode, but instead of allocating new byte slice,
 // it writes output to w.
 // Returns w.Write() result.
@@ -258,6 +623,103 @@ func (req *EncodeRequest) EncodeHexString() string {
 	return buf.String()
 }
 
+// Operands returns instruction operands in the order
+// they appear in assembly syntax, including implicit ones.
+// See DecodedInst.Operands.
+//
+// Instruction is encoded to collect operands info,
+// so the encoding error is reported via Encoder.Err.
+// Returns nil on error.
+func (req *EncodeRequest) Operands() []Operand {
+	inst := req.encoder.decode(req)
+	if inst == nil {
+		return nil
+	}
+	return inst.Operands()
+}
+
+// AllOperands is like Operands, but also includes suppressed operands.
+func (req *EncodeRequest) AllOperands() []Operand {
+	inst := req.encoder.decode(req)
+	if inst == nil {
+		return nil
+	}
+	return inst.AllOperands()
+}
+
+// Flags returns instruction RFLAGS usage info.
+// See DecodedInst.Flags.
+//
+// Returns zero FlagsInfo if req can't be encoded,
+// Encoder.Err tells why.
+func (req *EncodeRequest) Flags() FlagsInfo {
+	inst := req.encoder.decode(req)
+	if inst == nil {
+		return FlagsInfo{}
+	}
+	return inst.Flags()
+}
+
+// Category returns encoded instruction category name.
+// See DecodedInst.Category.
+//
+// Returns empty string on error.
+func (req *EncodeRequest) Category() string {
+	inst := req.encoder.decode(req)
+	if inst == nil {
+		return ""
+	}
+	return inst.Category()
+}
+
+// Extension returns encoded instruction ISA extension name.
+// See DecodedInst.Extension.
+//
+// Returns empty string on error.
+func (req *EncodeRequest) Extension() string {
+	inst := req.encoder.decode(req)
+	if inst == nil {
+		return ""
+	}
+	return inst.Extension()
+}
+
+// IsaSet returns encoded instruction ISA set name.
+// See DecodedInst.IsaSet.
+//
+// Returns empty string on error.
+func (req *EncodeRequest) IsaSet() string {
+	inst := req.encoder.decode(req)
+	if inst == nil {
+		return ""
+	}
+	return inst.IsaSet()
+}
+
+// Attributes returns encoded instruction XED attribute names.
+// See DecodedInst.Attributes.
+//
+// Returns nil on error.
+func (req *EncodeRequest) Attributes() []string {
+	inst := req.encoder.decode(req)
+	if inst == nil {
+		return nil
+	}
+	return inst.Attributes()
+}
+
+// CPL returns encoded instruction required privilege level.
+// See DecodedInst.CPL.
+//
+// Returns -1 on error.
+func (req *EncodeRequest) CPL() int {
+	inst := req.encoder.decode(req)
+	if inst == nil {
+		return -1
+	}
+	return inst.CPL()
+}
+
 // String returns assembly-like instruction representation.
 // Intended for debugging and pretty-printing (useful in tests).
 func (req *EncodeRequest) String() string {
@@ -268,6 +730,9 @@ func (req *EncodeRequest) String() string {
 	} else {
 		name = req.iclass.String()
 	}
+	if req.prefixes != 0 {
+		name = req.prefixes.String() + " " + name
+	}
 	if req.argc == 0 {
 		return name
 	}
@@ -275,25 +740,38 @@ func (req *EncodeRequest) String() string {
 	args := make([]string, req.argc)
 
 	for i := 0; i < int(req.argc); i++ {
+		imm, _ := req.immAt(i)
 		switch req.tags[i] {
 		default:
 			args[i] = "??"
 		case argReg:
 			args[i] = req.regs[i].String()
 		case argMem:
-			args[i] = memExprString(req)
+			mem, _ := req.memAt(i)
+			if mem.relative {
+				args[i] = fmt.Sprintf("mem%d[RIP->%#x]", mem.width, mem.target)
+			} else {
+				args[i] = memExprString(mem.width, mem.ptr)
+			}
+			if req.broadcast {
+				args[i] += req.broadcastString(mem.width)
+			}
 		case argUint8:
-			args[i] = fmt.Sprintf("uint8(%#x)", req.imm)
+			args[i] = fmt.Sprintf("uint8(%#x)", imm)
+		case argUint16:
+			args[i] = fmt.Sprintf("uint16(%#x)", imm)
 		case argUint32:
-			args[i] = fmt.Sprintf("uint32(%#x)", req.imm)
+			args[i] = fmt.Sprintf("uint32(%#x)", imm)
 		case argUint64:
-			args[i] = fmt.Sprintf("uint64(%#x)", req.imm)
+			args[i] = fmt.Sprintf("uint64(%#x)", imm)
 		case argInt8:
-			args[i] = fmt.Sprintf("int8(%#x)", int32(req.imm))
+			args[i] = fmt.Sprintf("int8(%#x)", int32(imm))
 		case argInt16:
-			args[i] = fmt.Sprintf("int16(%#x)", int32(req.imm))
+			args[i] = fmt.Sprintf("int16(%#x)", int32(imm))
 		case argInt32:
-			args[i] = fmt.Sprintf("int32(%#x)", int32(req.imm))
+			args[i] = fmt.Sprintf("int32(%#x)", int32(imm))
+		case argInt64:
+			args[i] = fmt.Sprintf("int64(%#x)", int64(imm))
 		case argRel8:
 			args[i] = fmt.Sprintf("rel8(%#x)", req.rel)
 		case argRel16:
@@ -303,9 +781,37 @@ func (req *EncodeRequest) String() string {
 		}
 	}
 
+	if req.zeroing {
+		args[0] += "{z}"
+	}
+	switch {
+	case req.rounding != roundingDefault:
+		args = append(args, "{"+req.rounding.String()+"}")
+	case req.sae:
+		args = append(args, "{sae}")
+	}
+
 	return name + " " + strings.Join(args, ", ")
 }
 
+// broadcastString returns EVEX-style broadcast decorator, like {1to8}.
+// Elements count is derived from the widest vector register argument.
+func (req *EncodeRequest) broadcastString(elemWidth uint16) string {
+	vecWidth := 0
+	for i, tag := range req.tags[:req.argc] {
+		if tag != argReg {
+			continue
+		}
+		if w := req.regs[i].Width(); w >= 128 && w > vecWidth {
+			vecWidth = w
+		}
+	}
+	if vecWidth == 0 || elemWidth == 0 {
+		return "{1toN}"
+	}
+	return fmt.Sprintf("{1to%d}", vecWidth/int(elemWidth))
+}
+
 func (req *EncodeRequest) pushTag(tag argTag) {
 	req.tags[req.argc] = tag
 	req.argc++
@@ -315,3 +821,258 @@ func (req *EncodeRequest) pushReg(reg xedRegister) {
 	req.regs[req.argc] = reg
 	req.pushTag(argReg)
 }
+
+func (req *EncodeRequest) pushImm(tag argTag, v uint64) {
+	if req.immCount() == 0 {
+		req.imm = v
+	} else {
+		req.imm1 = v
+	}
+	req.pushTag(tag)
+}
+
+// immCount returns the number of immediate arguments.
+func (req *EncodeRequest) immCount() int {
+	n := 0
+	for _, tag := range req.tags[:req.argc] {
+		if tag.isImm() {
+			n++
+		}
+	}
+	return n
+}
+
+// immAt returns immediate value for argument at specified index.
+// Second result reports whether it is the second immediate.
+func (req *EncodeRequest) immAt(index int) (uint64, bool) {
+	for _, tag := range req.tags[:index] {
+		if tag.isImm() {
+			return req.imm1, true
+		}
+	}
+	return req.imm, false
+}
+
+// memCount returns the number of memory arguments.
+func (req *EncodeRequest) memCount() int {
+	n := 0
+	for _, tag := range req.tags[:req.argc] {
+		if tag == argMem {
+			n++
+		}
+	}
+	return n
+}
+
+// memAt returns memory argument for argument at specified index.
+// Second result is a memory operand index (0 for MEM0, 1 for MEM1).
+func (req *EncodeRequest) memAt(index int) (memArg, int) {
+	for _, tag := range req.tags[:index] {
+		if tag == argMem {
+			return req.mems[1], 1
+		}
+	}
+	return req.mems[0], 0
+}
+
+// validate checks request arguments consistency.
+// Catches errors that XED would report in a less descriptive way.
+func (req *EncodeRequest) validate() error {
+	immCount := 0
+	for _, tag := range req.tags[:req.argc] {
+		if !tag.isImm() {
+			continue
+		}
+		immCount++
+		switch {
+		case immCount > 2:
+			return errTooManyImms
+		case immCount == 2 && tag != argUint8 && tag != argInt8:
+			return errSecondImmWidth
+		}
+	}
+
+	switch req.memCount() {
+	case 0, 1:
+	case 2:
+		ptr := req.mems[1].ptr
+		if ptr.Index != "" || ptr.Scale != 0 || ptr.Disp != 0 || req.mems[1].relative {
+			return errSecondMemForm
+		}
+	default:
+		return errTooManyMems
+	}
+
+	if req.broadcast && (req.rounding != roundingDefault || req.sae) {
+		return errEVEXConflict
+	}
+	if req.broadcast && req.memCount() == 0 {
+		return errBroadcastMem
+	}
+	if int(req.argc)+req.evexCount() > xedMaxOperands {
+		return errTooManyArgs
+	}
+
+	switch {
+	case req.prefixes&prefixRep != 0 && req.prefixes&prefixRepne != 0,
+		req.prefixes&prefixXacquire != 0 && req.prefixes&prefixXrelease != 0,
+		req.prefixes&prefixHintTaken != 0 && req.prefixes&prefixHintNotTaken != 0:
+		return errPrefixConflict
+	}
+
+	vsib := req.memCount() != 0 && req.iclass.IsVSIB()
+	addrWidth := req.easz.Width()
+	for _, mem := range req.mems[:req.memCount()] {
+		vecIndex := hasVectorIndex(mem.ptr)
+		switch {
+		case vecIndex && !vsib:
+			return fmt.Errorf("encoder: %s does not accept vector index register", req.iclass)
+		case !vecIndex && vsib:
+			return fmt.Errorf("encoder: %s requires vector index register", req.iclass)
+		}
+		width := ptrAddrWidth(mem.ptr)
+		if width == 0 {
+			continue
+		}
+		if mem.ptr.Base != "" && mem.ptr.Index != "" && !vecIndex &&
+			registerByName[mem.ptr.Base].Width() != registerByName[mem.ptr.Index].Width() {
+			return errMixedAddrWidth
+		}
+		if width == 16 && !validMem16(mem.ptr) {
+			return errMem16Form
+		}
+		if addrWidth != 0 && addrWidth != width {
+			return fmt.Errorf("encoder: %dbit address does not match %dbit address size",
+				width, addrWidth)
+		}
+		addrWidth = width
+	}
+	return nil
+}
+
+// disp8Candidate reports whether the first memory argument
+// displacement should be tried in disp8 form, returning the displacement.
+// Unless disp8 is requested explicitly, only instructions
+// that have EVEX forms are tried, including displacements
+// that are out of int8 range, but fit disp8*N with N up to 64.
+func (req *EncodeRequest) disp8Candidate() (int64, bool) {
+	if req.memCount() == 0 {
+		return 0, false
+	}
+	ptr := req.mems[0].ptr
+	addrWidth := req.addrWidth()
+	if addrWidth == 0 {
+		addrWidth = req.encoder.mode.AddrWidth()
+	}
+	disp := dispValue(ptr.Disp, addrWidth)
+	switch {
+	case req.dispWidth == 8:
+		return disp, true
+	case req.dispWidth != 0:
+		return 0, false
+	case !req.iclass.HasEVEXForm():
+		// No disp8*N, handled by xedMemOperand as usual.
+		return 0, false
+	case ptr.Base == "" || ptr.Base == "RIP" || ptr.Base == "EIP":
+		// Only disp32 (or disp16) is permitted.
+		return 0, false
+	case disp == 0 || addrWidth == 16:
+		// Handled by xedMemOperand as usual.
+		return 0, false
+	case disp >= -128 && disp <= 127:
+		return disp, true
+	case disp%2 == 0 && disp >= -128*64 && disp <= 127*64:
+		return disp, true
+	default:
+		return 0, false
+	}
+}
+
+// evexCount returns the number of XED operands
+// that are needed to encode AVX-512 modifiers.
+func (req *EncodeRequest) evexCount() int {
+	n := 0
+	if req.zeroing {
+		n++
+	}
+	if req.broadcast || req.sae || req.rounding != roundingDefault {
+		n++ // BCRC
+	}
+	if req.rounding != roundingDefault {
+		n += 2 // ROUNDC and SAE
+	} else if req.sae {
+		n++
+	}
+	return n
+}
+
+// addrWidth returns req effective address size in bits.
+// Explicitly set size has the highest priority,
+// then the size is inferred from memory arguments registers.
+// Returns 0 if address size should be selected by XED.
+func (req *EncodeRequest) addrWidth() int {
+	if req.easz != easzDefault {
+		return req.easz.Width()
+	}
+	for _, mem := range req.mems[:req.memCount()] {
+		if width := ptrAddrWidth(mem.ptr); width != 0 {
+			return width
+		}
+	}
+	return 0
+}
+
+// dispValue returns displacement truncated to addrWidth,
+// so 16 and 32bit addresses above signed maximum, like 0xc0000000,
+// become negative displacements that wrap around.
+// Returns disp as is if it does not fit addrWidth.
+func dispValue(disp int64, addrWidth int) int64 {
+	switch {
+	case addrWidth == 16 && disp >= math.MinInt16 && disp <= math.MaxUint16:
+		return int64(int16(disp))
+	case addrWidth == 32 && disp >= math.MinInt32 && disp <= math.MaxUint32:
+		return int64(int32(disp))
+	default:
+		return disp
+	}
+}
+
+// ptrAddrWidth returns ptr address size in bits that is
+// inferred from its registers.
+// Vector index does not affect address size.
+// Returns 0 if ptr has no base and general purpose index registers.
+func ptrAddrWidth(ptr Ptr) int {
+	if ptr.Base != "" {
+		return registerByName[ptr.Base].Width()
+	}
+	if hasVectorIndex(ptr) {
+		return 0
+	}
+	return registerByName[ptr.Index].Width()
+}
+
+// hasVectorIndex reports whether ptr uses XMM, YMM or ZMM
+// index register, as in VSIB addressing of gather/scatter instructions.
+func hasVectorIndex(ptr Ptr) bool {
+	return registerByName[ptr.Index].Width() >= 128
+}
+
+// validMem16 reports whether ptr is a valid 16bit addressing form:
+// one of BX, BP, SI or DI, or BX/BP base with SI/DI index.
+func validMem16(ptr Ptr) bool {
+	if ptr.Scale > 1 {
+		return false
+	}
+	switch ptr.Index {
+	case "":
+		switch ptr.Base {
+		case "BX", "BP", "SI", "DI":
+			return true
+		}
+		return false
+	case "SI", "DI":
+		return ptr.Base == "BX" || ptr.Base == "BP"
+	default:
+		return false
+	}
+}
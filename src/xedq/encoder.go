@@ -2,18 +2,47 @@ package xedq
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"strings"
 )
 
 const (
 	// Upper limit for instruction operands count.
 	maxArgLimit = 6
+
+	// Upper limit for instruction memory operands count.
+	maxMemArgs = 2
 )
 
 var (
-	errEncReqConvert = errors.New("encoder: request conversion failed")
+	errEncReqConvert  = errors.New("encoder: request conversion failed")
+	errTooManyImms    = errors.New("encoder: too many immediate arguments")
+	errSecondImmWidth = errors.New("encoder: second immediate must be 8bit")
+	errTooManyMems    = errors.New("encoder: too many memory arguments")
+	errSecondMemForm  = errors.New("encoder: second memory argument can only have a base")
+	errRIPTarget      = errors.New("encoder: RIP-relative target is out of disp32 range")
+	errMem16Form      = errors.New("encoder: invalid 16bit addressing form")
+	errMixedAddrWidth = errors.New("encoder: base and index registers have different sizes")
+	errPrefixConflict = errors.New("encoder: conflicting prefixes")
+	errEVEXConflict   = errors.New("encoder: broadcast can't be combined with rounding or SAE")
+	errBroadcastMem   = errors.New("encoder: broadcast requires memory argument")
+	errTooManyArgs    = errors.New("encoder: too many arguments")
 )
 
+// memArg is a memory argument of EncodeRequest.
+type memArg struct {
+	ptr   Ptr
+	width uint16 // Pointer size in bits
+
+	// For RIP-relative operands that are created by MemRIP,
+	// target is an absolute address that is converted
+	// into ptr.Disp during encoding.
+	target   uint64
+	relative bool
+}
+
 // MemExprParseFunc is a type of function that is used by Encoder
 // to handle MemExpr arguments.
 //
@@ -30,8 +59,11 @@ type MemExprParseFunc func(expr string) (Ptr, error)
 type Encoder struct {
 	tmpbuf buffer
 
-	mode xedState
-	err  error
+	mode        xedState
+	chip        xedChip
+	forms       encodingForms
+	strictForms bool
+	err         error
 
 	MemExprParser MemExprParseFunc
 }
@@ -39,6 +71,14 @@ type Encoder struct {
 // EncoderOption is a configuration function for NewEncoder.
 type EncoderOption func(*Encoder)
 
+// EncoderMode16 sets machine mode to 16bit protected mode.
+// Predefined EncoderOption.
+func EncoderMode16(enc *Encoder) { enc.mode = newXEDState16() }
+
+// EncoderModeReal16 sets machine mode to 16bit real mode.
+// Predefined EncoderOption.
+func EncoderModeReal16(enc *Encoder) { enc.mode = newXEDStateReal16() }
+
 // EncoderMode32 sets machine mode to 32bit.
 // Predefined EncoderOption.
 func EncoderMode32(enc *Encoder) { enc.mode = newXEDState32() }
@@ -47,6 +87,54 @@ func EncoderMode32(enc *Encoder) { enc.mode = newXEDState32() }
 // Predefined EncoderOption.
 func EncoderMode64(enc *Encoder) { enc.mode = newXEDState64() }
 
+// EncoderChip restricts valid instructions set to those
+// that are supported by the named chip, like "SKYLAKE" or "I486".
+// Requests for unsupported instructions fail with error.
+// See DecoderChip.
+//
+// Panics if chip name is unknown.
+func EncoderChip(name string) EncoderOption {
+	var tmpbuf buffer
+	chip := xedChipInvalid
+	if len(name) < bufferCapacity {
+		chip = newXEDChip(name, &tmpbuf)
+	}
+	if chip == xedChipInvalid {
+		panic("encoder: unknown chip " + name)
+	}
+	return func(enc *Encoder) { enc.chip = chip }
+}
+
+// EncoderPreferEVEX makes encoder prefer EVEX over VEX encoding.
+// Predefined EncoderOption. See EncodeRequest.SetPreferEVEX.
+func EncoderPreferEVEX(enc *Encoder) { enc.forms |= formEVEX }
+
+// EncoderPreferREX makes encoder emit REX prefix even if it is redundant.
+// Predefined EncoderOption. See EncodeRequest.SetPreferREX.
+func EncoderPreferREX(enc *Encoder) { enc.forms |= formREX }
+
+// EncoderPreferVEX3 makes encoder use 3-byte VEX prefix.
+// Predefined EncoderOption. See EncodeRequest.SetPreferVEX3.
+func EncoderPreferVEX3(enc *Encoder) { enc.forms |= formVEX3 }
+
+// EncoderStrictForms makes encoding fail if encoding form
+// preferences can't be satisfied, instead of ignoring them.
+// Useful for assembler validation, where the exact form matters.
+// Predefined EncoderOption.
+func EncoderStrictForms(enc *Encoder) { enc.strictForms = true }
+
+// EncoderPreferStoreForm makes encoder use MR ("store") opcode direction.
+// Predefined EncoderOption. See EncodeRequest.SetPreferStoreForm.
+func EncoderPreferStoreForm(enc *Encoder) {
+	enc.forms = enc.forms&^formLoad | formStore
+}
+
+// EncoderPreferLoadForm makes encoder use RM ("load") opcode direction.
+// Predefined EncoderOption. See EncodeRequest.SetPreferLoadForm.
+func EncoderPreferLoadForm(enc *Encoder) {
+	enc.forms = enc.forms&^formStore | formLoad
+}
+
 // NewEncoder returns encoder that is configured by specified options.
 //
 // Default options:
@@ -73,6 +161,11 @@ func (enc Encoder) Copy() Encoder {
 	return enc
 }
 
+// Decoder returns decoder that uses the same machine mode and chip as enc.
+func (enc *Encoder) Decoder() *Decoder {
+	return &Decoder{mode: enc.mode, chip: enc.chip}
+}
+
 // Err returns the last executed encoding request error.
 func (enc *Encoder) Err() error {
 	return enc.err
@@ -90,20 +183,155 @@ func (enc *Encoder) Request(name string) *EncodeRequest {
 	}
 }
 
+// encodeInst assembles req into enc.tmpbuf.
+// Returns encoded instruction length.
+func (enc *Encoder) encodeInst(req *EncodeRequest) (int, error) {
+	if err := req.validate(); err != nil {
+		return 0, err
+	}
+	if req.prefixes&(prefixLock|prefixRep|prefixRepne) != 0 {
+		resolved, err := enc.resolvePrefixes(req)
+		if err != nil {
+			return 0, err
+		}
+		req = resolved
+	}
+	if req.mems[0].relative {
+		resolved, err := enc.resolveRIPTarget(req)
+		if err != nil {
+			return 0, err
+		}
+		req = resolved
+	}
+	n, err := enc.encodeDisp(req)
+	if err != nil || (enc.chip == xedChipInvalid && req.prefixes&prefixesHint == 0) {
+		return n, err
+	}
+
+	// XED encoder does not check chip features and hint prefixes,
+	// so the result is verified by decoding it.
+	var xedd xedDecodedInst
+	err = xedDecode(&enc.mode, enc.chip, enc.tmpbuf.data[:n], &xedd)
+	switch {
+	case err == errInvalidForChip:
+		return 0, fmt.Errorf("encoder: %s is not supported by %s chip", req.iclass, enc.chip)
+	case err != nil:
+		return 0, err
+	}
+	if hints := req.prefixes & prefixesHint; xedd.HintPrefixes() != hints {
+		return 0, fmt.Errorf("encoder: %s does not accept %s prefix", req.iclass, hints)
+	}
+	return n, nil
+}
+
+// encodeDisp assembles req into enc.tmpbuf,
+// selecting the shortest displacement form.
+//
+// EVEX instructions scale disp8 by N (disp8*N compression),
+// where N depends on the instruction and its operands,
+// so disp8 form is verified by decoding it.
+func (enc *Encoder) encodeDisp(req *EncodeRequest) (int, error) {
+	disp, ok := req.disp8Candidate()
+	if !ok {
+		return enc.encodeForm(req)
+	}
+
+	disp8 := *req
+	disp8.dispWidth = 8
+	n, err := enc.encodeForm(&disp8)
+	if err == nil {
+		var xedd xedDecodedInst
+		err = xedDecode(&enc.mode, xedChipInvalid, enc.tmpbuf.data[:n], &xedd)
+		if err == nil && xedd.DispWidth(0) == 8 && xedd.Disp(0) == disp {
+			return n, nil
+		}
+	}
+	if req.dispWidth == 8 {
+		return 0, fmt.Errorf("encoder: displacement %#x can't be encoded as disp8", disp)
+	}
+
+	disp32 := *req
+	disp32.dispWidth = 32
+	return enc.encodeForm(&disp32)
+}
+
+// resolvePrefixes returns req copy with iclass that
+// matches its LOCK and REP prefixes.
+func (enc *Encoder) resolvePrefixes(req *EncodeRequest) (*EncodeRequest, error) {
+	resolved := *req
+
+	if req.prefixes&prefixLock != 0 {
+		name := req.iclass.String()
+		if !strings.HasSuffix(name, "_LOCK") {
+			resolved.iclass = xedIclassInvalid
+			if len(name+"_LOCK") < bufferCapacity {
+				resolved.iclass = newXEDIclass(name+"_LOCK", &enc.tmpbuf)
+			}
+		}
+		if resolved.iclass == xedIclassInvalid {
+			return nil, fmt.Errorf("encoder: %s does not accept LOCK prefix", req.iclass)
+		}
+	}
+
+	if rep := req.prefixes & (prefixRep | prefixRepne); rep != 0 {
+		if resolved.iclass.RepPrefix() != rep {
+			resolved.iclass = resolved.iclass.RepForm(rep == prefixRepne)
+		}
+		if resolved.iclass == xedIclassInvalid {
+			return nil, fmt.Errorf("encoder: %s does not accept %s prefix", req.iclass, rep)
+		}
+	}
+
+	return &resolved, nil
+}
+
+// resolveRIPTarget returns req copy with MemRIP target
+// converted into RIP-relative displacement.
+func (enc *Encoder) resolveRIPTarget(req *EncodeRequest) (*EncodeRequest, error) {
+	resolved := *req
+	resolved.mems[0].relative = false
+
+	// RIP-relative displacement is always 32bit,
+	// so instruction length does not depend on its value.
+	n, err := enc.encodeForm(&resolved)
+	if err != nil {
+		return nil, err
+	}
+	disp := int64(req.mems[0].target - (req.addr + uint64(n)))
+	if disp < math.MinInt32 || disp > math.MaxInt32 {
+		return nil, errRIPTarget
+	}
+	resolved.mems[0].ptr.Disp = disp
+	return &resolved, nil
+}
+
 // encode assembles req and returns result in freshly allocated slice of bytes.
 func (enc *Encoder) encode(req *EncodeRequest) []byte {
 	var n int
-	inst := newXEDInst(&enc.mode, req)
-	n, enc.err = xedEncode(&inst, &enc.tmpbuf)
+	n, enc.err = enc.encodeInst(req)
 	code := make([]byte, n)
 	copy(code, enc.tmpbuf.data[:])
 	return code
 }
 
+// decode assembles req and decodes the result back.
+// Used to query instruction properties that are known to decoder.
+// Returns nil on failure, enc.err holds the error.
+func (enc *Encoder) decode(req *EncodeRequest) *DecodedInst {
+	var n int
+	n, enc.err = enc.encodeInst(req)
+	if enc.err != nil {
+		return nil
+	}
+	dec := Decoder{mode: enc.mode, chip: enc.chip}
+	var decoded *DecodedInst
+	decoded, enc.err = dec.Decode(enc.tmpbuf.data[:n])
+	return decoded
+}
+
 // encodeTo assembles req and writes result to w.
 func (enc *Encoder) encodeTo(w io.Writer, req *EncodeRequest) (int, error) {
 	var n int
-	inst := newXEDInst(&enc.mode, req)
-	n, enc.err = xedEncode(&inst, &enc.tmpbuf)
+	n, enc.err = enc.encodeInst(req)
 	return w.Write(enc.tmpbuf.data[:n])
 }
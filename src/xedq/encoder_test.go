@@ -1,6 +1,8 @@
 package xedq
 
 import (
+	"encoding/hex"
+	"reflect"
 	"testing"
 )
 
@@ -8,10 +10,6 @@ func init() {
 	InitTables()
 }
 
-// TODO:
-// - test fixed disp width effect.
-// - test vmem (vsib) instructions.
-
 func TestEncoderMode32Eosz8(t *testing.T) {
 	encoder := NewEncoder(EncoderMode32)
 
@@ -72,6 +70,11 @@ func TestEncoderMode32Eosz32(t *testing.T) {
 		"35f0f00000":   {req("XOR").Reg("EAX").Uint32(0xf0f0)},
 		"31c0":         {req("XOR").Reg("EAX").Reg("EAX")},
 		"e834120000":   {req("CALL_NEAR").Rel32(0x1234)},
+		"c8200000":     {req("ENTER").Uint16(0x20).Uint8(0)},
+		"c8100001":     {req("ENTER").Int16(0x10).Int8(1)},
+		"8b0d000000c0": {req("MOV").Reg("ECX").MemExpr(32, "0xc0000000")},
+		"8b8000000080": {req("MOV").Reg("EAX").MemExpr(32, "EAX+0x80000000")},
+		"8b48ff":       {req("MOV").Reg("ECX").MemExpr(32, "EAX+0xffffffff")},
 	})
 }
 
@@ -92,6 +95,11 @@ func TestEncoderMode64Eosz8(t *testing.T) {
 		"340f":   {req("XOR").Reg("AL").Uint8(0x0f)},
 		"30c0":   {req("XOR").Reg("AL").Reg("AL")},
 		"7712":   {req("JNBE").Rel8(0x12)},
+		"a4":     {req("MOVSB").MemExpr(8, "RDI").MemExpr(8, "RSI")},
+		"a6":     {req("CMPSB").MemExpr(8, "RSI").MemExpr(8, "RDI")},
+		"a08877665544332211": {
+			req("MOV").Reg("AL").Mem(8, Ptr{Disp: 0x1122334455667788}),
+		},
 	})
 }
 
@@ -126,18 +134,26 @@ func TestEncoderMode64Eosz32(t *testing.T) {
 	}
 
 	runEncoderTests(t, map[string][]*EncodeRequest{
-		"83c077":         {req("ADD").Reg("EAX").Uint8(0x77)},
-		"0511223344":     {req("ADD").Reg("EAX").Uint32(0x44332211)},
-		"89c8":           {req("MOV").Reg("EAX").Reg("ECX")},
-		"89c1":           {req("MOV").Reg("ECX").Reg("EAX")},
-		"4531d0":         {req("XOR").Reg("R8D").Reg("R10D")},
-		"4531c2":         {req("XOR").Reg("R10D").Reg("R8D")},
-		"4183f70f":       {req("XOR").Reg("R15D").Uint8(0x0f)},
-		"4181f7f0f00000": {req("XOR").Reg("R15D").Uint32(0xf0f0)},
-		"678b0491":       {req("MOV").Reg("EAX").MemExpr(32, "ECX+EDX*4")},
-		"678b449144":     {req("MOV").Reg("EAX").MemExpr(32, "ECX+EDX*4+0x44")},
-		"678d0488":       {req("LEA").Reg("EAX").MemExpr(32, "EAX+ECX*4")},
-		"e834120000":     {req("CALL_NEAR").Rel32(0x1234)},
+		"83c077":           {req("ADD").Reg("EAX").Uint8(0x77)},
+		"0511223344":       {req("ADD").Reg("EAX").Uint32(0x44332211)},
+		"89c8":             {req("MOV").Reg("EAX").Reg("ECX")},
+		"89c1":             {req("MOV").Reg("ECX").Reg("EAX")},
+		"4531d0":           {req("XOR").Reg("R8D").Reg("R10D")},
+		"4531c2":           {req("XOR").Reg("R10D").Reg("R8D")},
+		"4183f70f":         {req("XOR").Reg("R15D").Uint8(0x0f)},
+		"4181f7f0f00000":   {req("XOR").Reg("R15D").Uint32(0xf0f0)},
+		"678b0491":         {req("MOV").Reg("EAX").MemExpr(32, "ECX+EDX*4")},
+		"678b449144":       {req("MOV").Reg("EAX").MemExpr(32, "ECX+EDX*4+0x44")},
+		"678d0488":         {req("LEA").Reg("EAX").MemExpr(32, "EAX+ECX*4")},
+		"e834120000":       {req("CALL_NEAR").Rel32(0x1234)},
+		"660f78c00408":     {req("EXTRQ").Reg("XMM0").Uint8(4).Uint8(8)},
+		"f20f78c10408":     {req("INSERTQ").Reg("XMM0").Reg("XMM1").Uint8(4).Uint8(8)},
+		"8b048d08000000":   {req("MOV").Reg("EAX").MemExpr(32, "RCX*4+8")},
+		"8b042508000000":   {req("MOV").Reg("EAX").Mem(32, Ptr{Disp: 8})},
+		"678b0c25000000c0": {req("MOV").Reg("ECX").MemExpr(32, "0xc0000000").SetEasz32()},
+		"a10010000000000000": {
+			req("MOV").Reg("EAX").MemExpr(32, "0x1000").SetDispWidth(64),
+		},
 	})
 }
 
@@ -149,20 +165,481 @@ func TestEncoderMode64Eosz64(t *testing.T) {
 	}
 
 	runEncoderTests(t, map[string][]*EncodeRequest{
-		"4883c077":       {req("ADD").Reg("RAX").Uint8(0x77)},
-		"480511223344":   {req("ADD").Reg("RAX").Uint32(0x44332211)},
-		"4889c8":         {req("MOV").Reg("RAX").Reg("RCX")},
-		"4889c1":         {req("MOV").Reg("RCX").Reg("RAX")},
-		"4d31d0":         {req("XOR").Reg("R8").Reg("R10")},
-		"4d31c2":         {req("XOR").Reg("R10").Reg("R8")},
-		"4983f70f":       {req("XOR").Reg("R15").Uint8(0x0f)},
-		"4981f7f0f00000": {req("XOR").Reg("R15").Uint32(0xf0f0)},
-		"488b04c8":       {req("MOV").Reg("RAX").MemExpr(64, "RAX+RCX*8")},
-		"488b44c844":     {req("MOV").Reg("RAX").MemExpr(64, "RAX+RCX*8+0x44")},
-		"488d0409":       {req("LEA").Reg("RAX").MemExpr(64, "RCX+RCX")},
+		"4883c077":           {req("ADD").Reg("RAX").Uint8(0x77)},
+		"480511223344":       {req("ADD").Reg("RAX").Uint32(0x44332211)},
+		"4889c8":             {req("MOV").Reg("RAX").Reg("RCX")},
+		"4889c1":             {req("MOV").Reg("RCX").Reg("RAX")},
+		"4d31d0":             {req("XOR").Reg("R8").Reg("R10")},
+		"4d31c2":             {req("XOR").Reg("R10").Reg("R8")},
+		"4983f70f":           {req("XOR").Reg("R15").Uint8(0x0f)},
+		"4981f7f0f00000":     {req("XOR").Reg("R15").Uint32(0xf0f0)},
+		"488b04c8":           {req("MOV").Reg("RAX").MemExpr(64, "RAX+RCX*8")},
+		"488b44c844":         {req("MOV").Reg("RAX").MemExpr(64, "RAX+RCX*8+0x44")},
+		"488d0409":           {req("LEA").Reg("RAX").MemExpr(64, "RCX+RCX")},
+		"c8200000":           {req("ENTER").Uint16(0x20).Uint8(0)},
+		"48a5":               {req("MOVSQ").MemExpr(64, "RDI").MemExpr(64, "RSI")},
+		"ff30":               {req("PUSH").MemExpr(64, "RAX")},
+		"8f00":               {req("POP").MemExpr(64, "RAX")},
+		"64488b042528000000": {req("MOV").Reg("RAX").MemExpr(64, "FS:0x28")},
+		"65488b042530000000": {req("MOV").Reg("RAX").MemExpr(64, "GS:0x30")},
+		"64488b4008":         {req("MOV").Reg("RAX").MemExpr(64, "FS:RAX+8")},
+		"48b88877665544332211": {
+			req("MOV").Reg("RAX").Uint64(0x1122334455667788),
+		},
+		"48b8ffffffffffffffff": {req("MOV").Reg("RAX").Int64(-1)},
+		"48a1efcdab8967452301": {
+			req("MOV").Reg("RAX").Mem(64, Ptr{Disp: 0x0123456789abcdef}),
+		},
+		"48a3efcdab8967452301": {
+			req("MOV").Mem(64, Ptr{Disp: 0x0123456789abcdef}).Reg("RAX"),
+		},
+	})
+}
+
+func TestEncoderMode16(t *testing.T) {
+	for _, mode := range []EncoderOption{EncoderMode16, EncoderModeReal16} {
+		encoder := NewEncoder(mode)
+		req := encoder.Request
+
+		runEncoderTests(t, map[string][]*EncodeRequest{
+			"89c8":     {req("MOV").Reg("AX").Reg("CX")},
+			"b83412":   {req("MOV").Reg("AX").Uint16(0x1234)},
+			"6689c8":   {req("MOV").SetEosz32().Reg("EAX").Reg("ECX")},
+			"8b00":     {req("MOV").Reg("AX").MemExpr(16, "BX+SI")},
+			"8b4104":   {req("MOV").Reg("AX").MemExpr(16, "BX+DI+4")},
+			"8b4600":   {req("MOV").Reg("AX").MemExpr(16, "BP")},
+			"8b4afe":   {req("MOV").Reg("CX").MemExpr(16, "BP+SI-2")},
+			"8b873412": {req("MOV").Reg("AX").MemExpr(16, "BX+0x1234")},
+			"8b063412": {req("MOV").Reg("AX").MemExpr(16, "0x1234")},
+			"678b00":   {req("MOV").Reg("AX").MemExpr(16, "EAX")},
+		})
+
+		runEncoderErrorTests(t, []*EncodeRequest{
+			req("MOV").Reg("AX").MemExpr(16, "AX"),
+			req("MOV").Reg("AX").MemExpr(16, "BX+BP"),
+			req("MOV").Reg("AX").MemExpr(16, "SI+DI"),
+			req("MOV").Reg("AX").MemExpr(16, "BX+SI*2"),
+			req("MOV").Reg("AX").MemExpr(16, "SI*1"),
+		})
+	}
+}
+
+func TestEncoderRIPRelative(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+
+	req := func(name string) *EncodeRequest {
+		return encoder.Request(name).SetEosz64()
+	}
+
+	runEncoderTests(t, map[string][]*EncodeRequest{
+		"488b0500000000": {
+			req("MOV").Reg("RAX").MemExpr(64, "RIP"),
+			req("MOV").Reg("RAX").MemRIP(64, 7),
+		},
+		"488b0510000000": {
+			req("MOV").Reg("RAX").MemExpr(64, "RIP+0x10"),
+			req("MOV").Reg("RAX").Mem(64, Ptr{Base: "RIP", Disp: 0x10}),
+			req("MOV").Reg("RAX").MemRIP(64, 0x1017).SetAddr(0x1000),
+		},
+		"488d05f9ffffff": {
+			req("LEA").Reg("RAX").MemExpr(64, "RIP-7"),
+			req("LEA").Reg("RAX").MemRIP(64, 0x1000).SetAddr(0x1000),
+		},
+		"c705f6ffffff78563412": {
+			encoder.Request("MOV").SetEosz32().MemRIP(32, 0x2000).Uint32(0x12345678).SetAddr(0x2000),
+		},
+	})
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		req("MOV").Reg("RAX").MemRIP(64, 1<<40),
+		req("MOVSB").MemExpr(8, "RDI").MemRIP(8, 0),
+	})
+
+	want := "LEA/64 RAX, mem64[RIP->0x1000]"
+	if have := req("LEA").Reg("RAX").MemRIP(64, 0x1000).String(); have != want {
+		t.Errorf("string mismatch:\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestEncoderAddrWidth(t *testing.T) {
+	encoder64 := NewEncoder(EncoderMode64)
+	encoder32 := NewEncoder(EncoderMode32)
+
+	runEncoderTests(t, map[string][]*EncodeRequest{
+		"8b0491": {
+			encoder64.Request("MOV").Reg("EAX").MemExpr(32, "RCX+RDX*4"),
+			encoder64.Request("MOV").Reg("EAX").MemExpr(32, "RCX+RDX*4").SetEasz64(),
+		},
+		"678b0491": {
+			encoder64.Request("MOV").Reg("EAX").MemExpr(32, "ECX+EDX*4"),
+			encoder64.Request("MOV").Reg("EAX").MemExpr(32, "ECX+EDX*4").SetEasz32(),
+		},
+		"678b042500100000": {
+			encoder64.Request("MOV").Reg("EAX").MemExpr(32, "0x1000").SetEasz32(),
+		},
+		"67a4": {
+			encoder64.Request("MOVSB").MemExpr(8, "EDI").MemExpr(8, "ESI"),
+		},
+		"678b063412": {
+			encoder32.Request("MOV").Reg("EAX").MemExpr(32, "0x1234").SetEasz16(),
+		},
+		"678b00": {
+			encoder32.Request("MOV").Reg("EAX").MemExpr(32, "BX+SI"),
+		},
+	})
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		encoder64.Request("MOV").Reg("EAX").MemExpr(32, "EAX+RCX"),
+		encoder64.Request("MOV").Reg("EAX").MemExpr(32, "RAX+ECX*2"),
+		encoder64.Request("MOV").Reg("EAX").MemExpr(32, "RAX").SetEasz32(),
+		encoder64.Request("MOVSB").MemExpr(8, "EDI").MemExpr(8, "RSI"),
+	})
+}
+
+func TestEncoderPrefixes(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+
+	req := func(name string) *EncodeRequest {
+		return encoder.Request(name).SetEosz32()
+	}
+
+	runEncoderTests(t, map[string][]*EncodeRequest{
+		"f00fb10f": {
+			req("CMPXCHG").SetLock().MemExpr(32, "RDI").Reg("ECX"),
+			req("CMPXCHG_LOCK").MemExpr(32, "RDI").Reg("ECX"),
+			req("CMPXCHG_LOCK").SetLock().MemExpr(32, "RDI").Reg("ECX"),
+		},
+		"f3a4": {
+			encoder.Request("MOVSB").SetRep().MemExpr(8, "RDI").MemExpr(8, "RSI"),
+		},
+		"f3a6": {
+			encoder.Request("CMPSB").SetRep().MemExpr(8, "RSI").MemExpr(8, "RDI"),
+		},
+		"f2ae": {
+			encoder.Request("SCASB").SetRepne().MemExpr(8, "RDI"),
+		},
+		"f2f00fb10f": {
+			req("CMPXCHG").SetXacquire().SetLock().MemExpr(32, "RDI").Reg("ECX"),
+		},
+		"f3f00fb10f": {
+			req("CMPXCHG").SetXrelease().SetLock().MemExpr(32, "RDI").Reg("ECX"),
+		},
+		"f3c60700": {
+			encoder.Request("MOV").SetEosz8().SetXrelease().MemExpr(8, "RDI").Uint8(0),
+		},
+		"3e7412": {encoder.Request("JZ").SetHintTaken().Rel8(0x12)},
+		"2e7412": {encoder.Request("JZ").SetHintNotTaken().Rel8(0x12)},
+	})
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		req("ADD").SetLock().Reg("EAX").Reg("ECX"),
+		req("MOV").SetLock().MemExpr(32, "RDI").Reg("ECX"),
+		req("ADD").SetRep().Reg("EAX").Reg("ECX"),
+		req("ADD").SetXacquire().Reg("EAX").Reg("ECX"),
+		req("ADD").SetHintTaken().Reg("EAX").Reg("ECX"),
+		encoder.Request("MOVSB").SetRep().SetRepne().MemExpr(8, "RDI").MemExpr(8, "RSI"),
+		encoder.Request("JZ").SetHintTaken().SetHintNotTaken().Rel8(0x12),
+	})
+}
+
+func TestEncoderEVEX(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+
+	vaddpd := func(mask string) *EncodeRequest {
+		return encoder.Request("VADDPD").Reg("ZMM0").Reg(mask).Reg("ZMM1")
+	}
+
+	runEncoderTests(t, map[string][]*EncodeRequest{
+		"62f1f54958c2": {vaddpd("K1").Reg("ZMM2")},
+		"62f1f5c958c2": {vaddpd("K1").Reg("ZMM2").SetZeroing()},
+		"62f1f51858c2": {vaddpd("K0").Reg("ZMM2").SetRoundingRN()},
+		"62f1f53858c2": {vaddpd("K0").Reg("ZMM2").SetRoundingRD()},
+		"62f1f55858c2": {vaddpd("K0").Reg("ZMM2").SetRoundingRU()},
+		"62f1f57858c2": {vaddpd("K0").Reg("ZMM2").SetRoundingRZ()},
+		"62f1f5585800": {vaddpd("K0").MemExpr(64, "RAX").SetBroadcast()},
+		"62f1f5d95800": {vaddpd("K1").MemExpr(64, "RAX").SetBroadcast().SetZeroing()},
+		"62f1fd182ec1": {encoder.Request("VUCOMISD").Reg("XMM0").Reg("XMM1").SetSAE()},
+	})
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		vaddpd("K0").MemExpr(64, "RAX").SetBroadcast().SetRoundingRN(),
+		vaddpd("K0").MemExpr(64, "RAX").SetBroadcast().SetSAE(),
+		vaddpd("K0").Reg("ZMM2").SetBroadcast(),
+	})
+}
+
+func TestEncoderDispWidth(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+
+	mov := func(expr string) *EncodeRequest {
+		return encoder.Request("MOV").SetEosz32().Reg("EAX").MemExpr(32, expr)
+	}
+	vaddpd := func(expr string) *EncodeRequest {
+		return encoder.Request("VADDPD").Reg("ZMM0").Reg("K0").Reg("ZMM1").MemExpr(512, expr)
+	}
+
+	runEncoderTests(t, map[string][]*EncodeRequest{
+		"8b4008":       {mov("RAX+8"), mov("RAX+8").SetDispWidth(8)},
+		"8b4000":       {mov("RAX").SetDispWidth(8)},
+		"8b8008000000": {mov("RAX+8").SetDispWidth(32)},
+		"8b8000010000": {mov("RAX+0x100")},
+		"8b80c0000000": {mov("RAX+0xc0")},
+		// disp8*N, N=64.
+		"62f1f548584001":       {vaddpd("RAX+0x40"), vaddpd("RAX+0x40").SetDispWidth(8)},
+		"62f1f5485840ff":       {vaddpd("RAX-0x40")},
+		"62f1f5485880c0000000": {vaddpd("RAX+0xc0").SetDispWidth(32)},
+		"62f1f548588044000000": {vaddpd("RAX+0x44")},
+		"62f1f548588000200000": {vaddpd("RAX+0x2000")},
+		// disp8*N, N=8 for broadcast of 64bit elements.
+		"62f1f558584001": {
+			encoder.Request("VADDPD").Reg("ZMM0").Reg("K0").Reg("ZMM1").MemExpr(64, "RAX+8").SetBroadcast(),
+		},
+	})
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		mov("RAX+0x100").SetDispWidth(8),
+		vaddpd("RAX+0x44").SetDispWidth(8),
+	})
+}
+
+func TestEncoderForms(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+	evexEncoder := NewEncoder(EncoderMode64, EncoderPreferEVEX)
+	loadEncoder := NewEncoder(EncoderMode64, EncoderPreferLoadForm)
+
+	req := func(name string) *EncodeRequest {
+		return encoder.Request(name).SetEosz32()
+	}
+	vaddpd := func(enc *Encoder) *EncodeRequest {
+		return enc.Request("VADDPD").Reg("XMM0").Reg("XMM1").Reg("XMM2")
+	}
+
+	runEncoderTests(t, map[string][]*EncodeRequest{
+		"89c8": {
+			req("MOV").Reg("EAX").Reg("ECX"),
+			req("MOV").Reg("EAX").Reg("ECX").SetPreferStoreForm(),
+			req("MOV").Reg("EAX").Reg("ECX").SetPreferVEX3(),
+			loadEncoder.Request("MOV").SetEosz32().Reg("EAX").Reg("ECX").SetPreferStoreForm(),
+		},
+		"8bc1": {
+			req("MOV").Reg("EAX").Reg("ECX").SetPreferLoadForm(),
+			loadEncoder.Request("MOV").SetEosz32().Reg("EAX").Reg("ECX"),
+		},
+		"03c1":   {req("ADD").Reg("EAX").Reg("ECX").SetPreferLoadForm()},
+		"4c8bc0": {encoder.Request("MOV").SetEosz64().Reg("R8").Reg("RAX").SetPreferLoadForm()},
+		"4989c0": {encoder.Request("MOV").SetEosz64().Reg("R8").Reg("RAX").SetPreferStoreForm()},
+		"8b01": {
+			// Memory forms have only one direction.
+			req("MOV").Reg("EAX").MemExpr(32, "RCX").SetPreferStoreForm(),
+		},
+		"4089c8": {req("MOV").Reg("EAX").Reg("ECX").SetPreferREX()},
+		"c5f158c2": {
+			vaddpd(encoder),
+			vaddpd(encoder).SetPreferREX(),
+		},
+		"c4e17158c2": {vaddpd(encoder).SetPreferVEX3()},
+		"62f1f50858c2": {
+			vaddpd(encoder).SetPreferEVEX(),
+			vaddpd(evexEncoder),
+		},
+	})
+
+	strict := NewEncoder(EncoderMode64, EncoderStrictForms)
+	strict32 := NewEncoder(EncoderMode32, EncoderStrictForms)
+	strictMov := func(enc *Encoder) *EncodeRequest {
+		return enc.Request("MOV").SetEosz32().Reg("EAX").Reg("ECX")
+	}
+
+	runEncoderTests(t, map[string][]*EncodeRequest{
+		"89c8":         {strictMov(strict).SetPreferStoreForm()},
+		"8bc1":         {strictMov(strict).SetPreferLoadForm()},
+		"4089c8":       {strictMov(strict).SetPreferREX()},
+		"c4e17158c2":   {vaddpd(strict).SetPreferVEX3()},
+		"62f1f50858c2": {vaddpd(strict).SetPreferEVEX()},
+	})
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		strictMov(strict).SetPreferEVEX(),
+		strictMov(strict).SetPreferVEX3(),
+		strictMov(strict32).SetPreferREX(),
+		vaddpd(strict).SetPreferREX(),
+		strict.Request("MOV").SetEosz32().Reg("EAX").MemExpr(32, "RCX").SetPreferStoreForm(),
+	})
+}
+
+func TestEncoderEncodeAll(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64, EncoderPreferLoadForm)
+	req := func(name string) *EncodeRequest {
+		return encoder.Request(name).SetEosz32()
+	}
+
+	tests := []struct {
+		req       *EncodeRequest
+		encodings []string
+	}{
+		{
+			req("MOV").Reg("EAX").Reg("ECX"),
+			[]string{
+				"89c8 MOV_GPRv_GPRv_89",
+				"4089c8 MOV_GPRv_GPRv_89",
+				"8bc1 MOV_GPRv_GPRv_8B",
+				"408bc1 MOV_GPRv_GPRv_8B",
+			},
+		},
+		{
+			req("MOV").Reg("EAX").MemExpr(32, "RCX+8").SetDispWidth(32),
+			[]string{
+				"8b4108 MOV_GPRv_MEMv",
+				"8b8108000000 MOV_GPRv_MEMv",
+				"408b4108 MOV_GPRv_MEMv",
+				"408b8108000000 MOV_GPRv_MEMv",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		encodings := test.req.EncodeAll()
+		if err := encoder.Err(); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.req, err)
+			continue
+		}
+		var have []string
+		for _, encoding := range encodings {
+			have = append(have, hex.EncodeToString(encoding.Bytes)+" "+encoding.Iform)
+		}
+		if !reflect.DeepEqual(have, test.encodings) {
+			t.Errorf("%s:\nexpected %q\ngot %q", test.req, test.encodings, have)
+		}
+	}
+
+	vaddpd := encoder.Request("VADDPD").Reg("XMM0").Reg("XMM1").Reg("XMM2")
+	have := make(map[string]bool)
+	for _, encoding := range vaddpd.EncodeAll() {
+		have[hex.EncodeToString(encoding.Bytes)] = true
+	}
+	for _, want := range []string{"c5f158c2", "c4e17158c2", "62f1f50858c2"} {
+		if !have[want] {
+			t.Errorf("%s: missing %s encoding", vaddpd, want)
+		}
+	}
+
+	req("MOV").Reg("EAX").Reg("XMM0").EncodeAll()
+	if encoder.Err() == nil {
+		t.Errorf("expected encoding error")
+	}
+}
+
+func TestEncoderVSIB(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+	req := encoder.Request
+
+	runEncoderTests(t, map[string][]*EncodeRequest{
+		"c4e2699004c8": {
+			req("VPGATHERDD").Reg("XMM0").MemExpr(32, "RAX+XMM1*8").Reg("XMM2"),
+		},
+		"62f27d4990048d00000000": {
+			req("VPGATHERDD").Reg("ZMM0").Reg("K1").MemExpr(32, "ZMM1*4"),
+		},
+		"62f27d49a20c98": {
+			req("VSCATTERDPS").MemExpr(32, "RAX+ZMM3*4").Reg("K1").Reg("ZMM1"),
+		},
+	})
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		req("MOV").Reg("EAX").MemExpr(32, "RAX+XMM1*4"),
+		req("VPGATHERDD").Reg("ZMM0").Reg("K1").MemExpr(32, "RAX+RCX*4"),
+	})
+}
+
+func TestEncoderImmErrors(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		encoder.Request("ENTER").Uint16(0x20).Uint16(1),
+		encoder.Request("EXTRQ").Reg("XMM0").Uint8(4).Uint32(8),
+		encoder.Request("EXTRQ").Reg("XMM0").Uint8(4).Uint8(8).Uint8(1),
+	})
+}
+
+func TestEncoderMemErrors(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+
+	runEncoderErrorTests(t, []*EncodeRequest{
+		encoder.Request("MOVSB").MemExpr(8, "RDI").MemExpr(8, "RSI+1"),
+		encoder.Request("MOVSB").MemExpr(8, "RDI").MemExpr(8, "RSI+RCX"),
+		encoder.Request("MOVSB").MemExpr(8, "RDI").MemExpr(8, "RSI").MemExpr(8, "RAX"),
 	})
 }
 
+func TestEncodeRequestString(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+
+	tests := map[string]*EncodeRequest{
+		"ENTER/64 uint16(0x20), uint8(0x1)":           encoder.Request("ENTER").SetEosz64().Uint16(0x20).Uint8(1),
+		"EXTRQ/?? XMM0, uint8(0x4), int8(-0x1)":       encoder.Request("EXTRQ").Reg("XMM0").Uint8(4).Int8(-1),
+		"ADD/32 EAX, int32(-0x10)":                    encoder.Request("ADD").SetEosz32().Reg("EAX").Int32(-0x10),
+		"MOVSB/8 mem8[RDI], mem8[RSI]":                encoder.Request("MOVSB").SetEosz8().MemExpr(8, "RDI").MemExpr(8, "RSI"),
+		"LOCK CMPXCHG/32 mem32[RDI], ECX":             encoder.Request("CMPXCHG").SetEosz32().SetLock().MemExpr(32, "RDI").Reg("ECX"),
+		"XACQUIRE LOCK ADD/32 mem32[RDI], ECX":        encoder.Request("ADD").SetEosz32().SetLock().SetXacquire().MemExpr(32, "RDI").Reg("ECX"),
+		"REP MOVSB/8 mem8[RDI], mem8[RSI]":            encoder.Request("MOVSB").SetEosz8().SetRep().MemExpr(8, "RDI").MemExpr(8, "RSI"),
+		"HINT_TAKEN JZ/?? rel8(0x12)":                 encoder.Request("JZ").SetHintTaken().Rel8(0x12),
+		"VADDPD/?? ZMM0{z}, K1, ZMM1, ZMM2":           encoder.Request("VADDPD").Reg("ZMM0").Reg("K1").Reg("ZMM1").Reg("ZMM2").SetZeroing(),
+		"VADDPD/?? ZMM0, K0, ZMM1, ZMM2, {rn-sae}":    encoder.Request("VADDPD").Reg("ZMM0").Reg("K0").Reg("ZMM1").Reg("ZMM2").SetRoundingRN(),
+		"VADDPS/?? ZMM0, K0, ZMM1, mem32[RAX]{1to16}": encoder.Request("VADDPS").Reg("ZMM0").Reg("K0").Reg("ZMM1").MemExpr(32, "RAX").SetBroadcast(),
+		"VUCOMISD/?? XMM0, XMM1, {sae}":               encoder.Request("VUCOMISD").Reg("XMM0").Reg("XMM1").SetSAE(),
+		"MOV/64 RAX, mem64[FS:0x28]":                  encoder.Request("MOV").SetEosz64().Reg("RAX").MemExpr(64, "FS:0x28"),
+		"MOV/64 mem64[GS:RAX+0x8], RCX":               encoder.Request("MOV").SetEosz64().MemExpr(64, "GS:RAX+8").Reg("RCX"),
+	}
+
+	for want, req := range tests {
+		if have := req.String(); have != want {
+			t.Errorf("string mismatch:\nhave: %q\nwant: %q", have, want)
+		}
+	}
+}
+
+func TestEncoderChip(t *testing.T) {
+	tests := []struct {
+		chip string
+		req  func(*Encoder) *EncodeRequest
+		ok   bool
+	}{
+		{"SKYLAKE_SERVER", vaddpdEVEX, true},
+		{"SKYLAKE", vaddpdEVEX, false},
+		{"SKYLAKE", vaddpdVEX, true},
+		{"PENTIUM", vaddpdVEX, false},
+		{"PENTIUM", movEAX, true},
+	}
+
+	for _, test := range tests {
+		encoder := NewEncoder(EncoderMode32, EncoderChip(test.chip))
+		req := test.req(encoder)
+		req.Encode()
+		if err := encoder.Err(); (err == nil) != test.ok {
+			t.Errorf("%s on %s: unexpected error state: %v", req, test.chip, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for unknown chip")
+		}
+	}()
+	NewEncoder(EncoderChip("NO_SUCH_CHIP"))
+}
+
+func vaddpdEVEX(enc *Encoder) *EncodeRequest {
+	return enc.Request("VADDPD").Reg("XMM0").Reg("K4").Reg("XMM2").Reg("XMM3")
+}
+
+func vaddpdVEX(enc *Encoder) *EncodeRequest {
+	return enc.Request("VADDPD").Reg("XMM0").Reg("XMM2").Reg("XMM3")
+}
+
+func movEAX(enc *Encoder) *EncodeRequest {
+	return enc.Request("MOV").Reg("EAX").Reg("ECX")
+}
+
 func runEncoderTests(t *testing.T, tests map[string][]*EncodeRequest) {
 	for encoding, requests := range tests {
 		for _, req := range requests {
@@ -182,3 +659,12 @@ func runEncoderTests(t *testing.T, tests map[string][]*EncodeRequest) {
 		}
 	}
 }
+
+func runEncoderErrorTests(t *testing.T, tests []*EncodeRequest) {
+	for _, req := range tests {
+		req.Encode()
+		if req.encoder.Err() == nil {
+			t.Errorf("%s: expected encoding error", req)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package xedq
+
+import (
+	"fmt"
+	"strings"
+)
+
+// encodingForms is a set of encoding form preferences.
+type encodingForms uint8
+
+// All encoding form preferences.
+const (
+	formEVEX encodingForms = 1 << iota
+	formREX
+	formVEX3
+	formStore
+	formLoad
+)
+
+// formsDirection is a set of opcode direction preferences.
+const formsDirection = formStore | formLoad
+
+// formsAll is a set of all encoding form preferences.
+const formsAll = formEVEX | formREX | formVEX3 | formsDirection
+
+// encodingFormNames lists encoding forms in order they are printed.
+var encodingFormNames = []struct {
+	form encodingForms
+	name string
+}{
+	{formEVEX, "EVEX"},
+	{formREX, "REX"},
+	{formVEX3, "VEX3"},
+	{formStore, "store"},
+	{formLoad, "load"},
+}
+
+// String returns space-separated encoding form names.
+func (forms encodingForms) String() string {
+	var names []string
+	for _, f := range encodingFormNames {
+		if forms&f.form != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// Encoding is one of the valid instruction encodings.
+type Encoding struct {
+	// Bytes is encoded instruction.
+	Bytes []byte
+
+	// Iform is XED instruction form name, like "MOV_GPRv_GPRv_89".
+	Iform string
+}
+
+// formsFor returns encoding preferences for req.
+// EVEX, REX and VEX3 preferences of req and encoder are combined,
+// request opcode direction preference replaces the encoder one.
+func (enc *Encoder) formsFor(req *EncodeRequest) encodingForms {
+	forms := enc.forms
+	if req.forms&formsDirection != 0 {
+		forms &^= formsDirection
+	}
+	return forms | req.forms
+}
+
+// encodeForm assembles req into enc.tmpbuf with respect to
+// encoding form preferences.
+//
+// Unsatisfied preferences are ignored,
+// unless encoder is in strict forms mode.
+func (enc *Encoder) encodeForm(req *EncodeRequest) (int, error) {
+	n, err := enc.encodePreferredForm(req)
+	if err != nil || !enc.strictForms {
+		return n, err
+	}
+	if missing := enc.missingForms(enc.formsFor(req), enc.tmpbuf.data[:n]); missing != 0 {
+		return 0, fmt.Errorf("encoder: %s can't be encoded in %s form", req.iclass, missing)
+	}
+	return n, nil
+}
+
+// encodePreferredForm is encodeForm without strict forms check.
+//
+// EVEX and REX preferences are passed to XED.
+// If XED can't satisfy them, they are ignored.
+// XED encoder has no VEX3 and opcode direction options,
+// so they are applied by rewriting the encoded bytes,
+// the result is kept only if it decodes to the same instruction.
+func (enc *Encoder) encodePreferredForm(req *EncodeRequest) (int, error) {
+	forms := enc.formsFor(req)
+	if enc.mode.AddrWidth() != 64 {
+		forms &^= formREX
+	}
+
+	var n int
+	err := errEncReqConvert
+	if forms&(formEVEX|formREX) != 0 {
+		knobsReq := req
+		if forms&formEVEX != 0 {
+			knobsReq = req.withMask()
+		}
+		inst := newXEDInst(&enc.mode, knobsReq)
+		n, err = xedEncode(&inst, forms, &enc.tmpbuf)
+		if err == nil {
+			// Knobs can produce invalid encodings, like REX before VEX.
+			var xedd xedDecodedInst
+			err = xedDecode(&enc.mode, xedChipInvalid, enc.tmpbuf.data[:n], &xedd)
+		}
+	}
+	if err != nil {
+		inst := newXEDInst(&enc.mode, req)
+		n, err = xedEncode(&inst, 0, &enc.tmpbuf)
+	}
+	if err != nil || forms&(formVEX3|formsDirection) == 0 {
+		return n, err
+	}
+
+	code := make([]byte, n)
+	copy(code, enc.tmpbuf.data[:n])
+	transformed := code
+	if forms&formVEX3 != 0 {
+		transformed = toVEX3(transformed)
+	}
+	if forms&formsDirection != 0 {
+		transformed = toDirection(transformed, forms&formStore != 0, enc.mode.AddrWidth() == 64)
+	}
+	if len(transformed) > xedMaxInstBytes || !enc.sameInst(code, transformed) {
+		return n, nil
+	}
+	copy(enc.tmpbuf.data[:], transformed)
+	return len(transformed), nil
+}
+
+// encodeAll assembles req with every combination of encoding form
+// preferences and displacement widths.
+// Returns unique encodings that decode to the same instruction
+// as the default one, which always goes first.
+func (enc *Encoder) encodeAll(req *EncodeRequest) []Encoding {
+	// Encoder preferences would hide some of the forms,
+	// strict mode would fail on the unsatisfiable ones.
+	all := enc.Copy()
+	all.forms = 0
+	all.strictForms = false
+
+	dispWidths := []int{0}
+	if req.memCount() != 0 {
+		dispWidths = []int{0, 8, 16, 32, 64}
+	}
+
+	var encodings []Encoding
+	seen := make(map[string]bool)
+	for forms := encodingForms(0); forms <= formsAll; forms++ {
+		if forms&formsDirection == formsDirection {
+			continue
+		}
+		for _, width := range dispWidths {
+			variant := *req
+			variant.forms = forms
+			variant.dispWidth = width
+			n, err := all.encodeInst(&variant)
+			if len(encodings) == 0 {
+				// The default encoding defines request validity.
+				if err != nil {
+					enc.err = err
+					return nil
+				}
+			} else if err != nil {
+				continue
+			}
+
+			code := make([]byte, n)
+			copy(code, all.tmpbuf.data[:n])
+			if seen[string(code)] {
+				continue
+			}
+			if len(encodings) != 0 && !all.sameInst(encodings[0].Bytes, code) {
+				continue
+			}
+			var xedd xedDecodedInst
+			if err := xedDecode(&all.mode, xedChipInvalid, code, &xedd); err != nil {
+				continue
+			}
+			seen[string(code)] = true
+			encodings = append(encodings, Encoding{Bytes: code, Iform: xedd.Iform()})
+		}
+	}
+
+	enc.err = nil
+	return encodings
+}
+
+// missingForms returns forms preferences that code does not satisfy.
+func (enc *Encoder) missingForms(forms encodingForms, code []byte) encodingForms {
+	var xedd xedDecodedInst
+	if xedDecode(&enc.mode, xedChipInvalid, code, &xedd) != nil {
+		return forms
+	}
+	missing := forms
+	if xedd.VexValid() == 2 {
+		missing &^= formEVEX
+	}
+	if xedd.HasREX() {
+		missing &^= formREX
+	}
+	if i := legacyPrefixesLen(code); xedd.VexValid() == 1 && code[i] == 0xc4 {
+		missing &^= formVEX3
+	}
+	if store, ok := opcodeDirection(code, enc.mode.AddrWidth() == 64); ok {
+		if store {
+			missing &^= formStore
+		} else {
+			missing &^= formLoad
+		}
+	}
+	return missing
+}
+
+// withMask returns req copy with K0 mask register inserted
+// after the first argument, as EVEX forms expect it there.
+// Returns req as is if it already has a mask register.
+func (req *EncodeRequest) withMask() *EncodeRequest {
+	if req.argc < 2 || int(req.argc) == maxArgLimit {
+		return req
+	}
+	if reg := req.regs[1]; req.tags[1] == argReg && reg >= regK && reg < regK+8 {
+		return req
+	}
+	masked := *req
+	copy(masked.tags[2:], req.tags[1:req.argc])
+	copy(masked.regs[2:], req.regs[1:req.argc])
+	masked.tags[1] = argReg
+	masked.regs[1] = regK
+	masked.argc++
+	return &masked
+}
+
+// sameInst reports whether a and b encode the same instruction.
+//
+// Instructions are compared by their iclass, explicit operands
+// and AVX-512 modifiers, so different forms of the same
+// instruction, like VEX and EVEX, are considered equal.
+func (enc *Encoder) sameInst(a, b []byte) bool {
+	var xeddA, xeddB xedDecodedInst
+	if xedDecode(&enc.mode, xedChipInvalid, a, &xeddA) != nil {
+		return false
+	}
+	if xedDecode(&enc.mode, xedChipInvalid, b, &xeddB) != nil {
+		return false
+	}
+	if xeddB.Len() != len(b) || xeddA.Iclass() != xeddB.Iclass() {
+		return false
+	}
+	if xeddA.HintPrefixes() != xeddB.HintPrefixes() {
+		return false
+	}
+	zeroingA, broadcastA, roundingA, saeA := xeddA.EVEX()
+	zeroingB, broadcastB, roundingB, saeB := xeddB.EVEX()
+	if zeroingA != zeroingB || broadcastA != broadcastB || roundingA != roundingB || saeA != saeB {
+		return false
+	}
+	opsA := explicitOperands(&xeddA)
+	opsB := explicitOperands(&xeddB)
+	if len(opsA) != len(opsB) {
+		return false
+	}
+	for i := range opsA {
+		if !sameOperand(opsA[i], opsB[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// explicitOperands returns xedd explicit operands.
+// K0 mask is skipped, as EVEX forms have it, while VEX forms do not.
+func explicitOperands(xedd *xedDecodedInst) []Operand {
+	n := xedd.NumOperands()
+	ops := make([]Operand, 0, n)
+	for i := 0; i < n; i++ {
+		op := xedd.Operand(i)
+		if op.Visibility != OperandExplicit {
+			continue
+		}
+		if op.Kind == OperandReg && op.Reg == "K0" {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// sameOperand reports whether x and y have the same value.
+// Operand names and access actions are not compared.
+func sameOperand(x, y Operand) bool {
+	return x.Kind == y.Kind &&
+		x.Width == y.Width &&
+		x.Reg == y.Reg &&
+		x.Mem == y.Mem &&
+		x.MemWidth == y.MemWidth &&
+		x.Imm == y.Imm &&
+		x.Rel == y.Rel
+}
+
+// legacyPrefixesLen returns the number of legacy prefix bytes
+// at the beginning of code.
+func legacyPrefixesLen(code []byte) int {
+	for i, b := range code {
+		switch b {
+		case 0x26, 0x2e, 0x36, 0x3e, 0x64, 0x65, 0x66, 0x67, 0xf0, 0xf2, 0xf3:
+			continue
+		default:
+			return i
+		}
+	}
+	return len(code)
+}
+
+// toVEX3 converts 2-byte VEX prefix to equivalent 3-byte VEX prefix.
+// Returns code as is if it has no 2-byte VEX prefix.
+func toVEX3(code []byte) []byte {
+	i := legacyPrefixesLen(code)
+	if i+1 >= len(code) || code[i] != 0xc5 {
+		return code
+	}
+	// C5 [R vvvv L pp] => C4 [R X B 00001] [W vvvv L pp],
+	// where inverted X and B are 1 and W is 0.
+	b1 := code[i+1]
+	out := make([]byte, 0, len(code)+1)
+	out = append(out, code[:i]...)
+	out = append(out, 0xc4, b1&0x80|0x61, b1&0x7f)
+	return append(out, code[i+2:]...)
+}
+
+// toDirection converts register-register form of
+// two-operand ALU or MOV instruction to MR ("store")
+// or RM ("load") opcode direction.
+// Returns code as is if there is no such form.
+func toDirection(code []byte, store, mode64 bool) []byte {
+	codeStore, ok := opcodeDirection(code, mode64)
+	if !ok || codeStore == store {
+		return code
+	}
+	i := legacyPrefixesLen(code)
+	rex := -1
+	if mode64 && code[i]&0xf0 == 0x40 {
+		rex = i
+		i++
+	}
+	op, modrm := code[i], code[i+1]
+
+	out := make([]byte, len(code))
+	copy(out, code)
+	out[i] = op ^ 0x02
+	reg, rm := modrm>>3&0x07, modrm&0x07
+	out[i+1] = 0xc0 | rm<<3 | reg
+	if rex != -1 {
+		// Swap REX.R and REX.B.
+		r, b := code[rex]>>2&1, code[rex]&1
+		out[rex] = code[rex]&^0x05 | b<<2 | r
+	}
+	return out
+}
+
+// opcodeDirection reports whether code is register-register form
+// of two-operand ALU or MOV instruction and whether it uses
+// MR ("store") opcode direction.
+func opcodeDirection(code []byte, mode64 bool) (store, ok bool) {
+	i := legacyPrefixesLen(code)
+	if mode64 && i < len(code) && code[i]&0xf0 == 0x40 {
+		i++
+	}
+	if i+1 >= len(code) {
+		return false, false
+	}
+	op, modrm := code[i], code[i+1]
+	hasDirection := (op < 0x40 && op&0x07 <= 3) || (op >= 0x88 && op <= 0x8b)
+	if !hasDirection || modrm>>6 != 3 {
+		return false, false
+	}
+	return op&0x02 == 0, true
+}
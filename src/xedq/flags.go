@@ -0,0 +1,106 @@
+package xedq
+
+import (
+	"strings"
+)
+
+// FlagSet is a set of RFLAGS bits.
+//
+// Bit positions match RFLAGS register layout,
+// so FlagSet can be used as a RFLAGS mask.
+type FlagSet uint32
+
+// RFLAGS bits.
+const (
+	FlagCF   FlagSet = 1 << 0  // Carry
+	FlagPF   FlagSet = 1 << 2  // Parity
+	FlagAF   FlagSet = 1 << 4  // Auxiliary carry
+	FlagZF   FlagSet = 1 << 6  // Zero
+	FlagSF   FlagSet = 1 << 7  // Sign
+	FlagTF   FlagSet = 1 << 8  // Trap
+	FlagIF   FlagSet = 1 << 9  // Interrupt enable
+	FlagDF   FlagSet = 1 << 10 // Direction
+	FlagOF   FlagSet = 1 << 11 // Overflow
+	FlagIOPL FlagSet = 3 << 12 // I/O privilege level (2 bits)
+	FlagNT   FlagSet = 1 << 14 // Nested task
+	FlagRF   FlagSet = 1 << 16 // Resume
+	FlagVM   FlagSet = 1 << 17 // Virtual-8086 mode
+	FlagAC   FlagSet = 1 << 18 // Alignment check
+	FlagVIF  FlagSet = 1 << 19 // Virtual interrupt
+	FlagVIP  FlagSet = 1 << 20 // Virtual interrupt pending
+	FlagID   FlagSet = 1 << 21 // CPUID availability
+
+	// x87 FPU condition codes.
+	// They are not RFLAGS bits, but XED tracks them in the same set.
+	FlagFC0 FlagSet = 1 << 28
+	FlagFC1 FlagSet = 1 << 29
+	FlagFC2 FlagSet = 1 << 30
+	FlagFC3 FlagSet = 1 << 31
+
+	// FlagsStatus is a set of arithmetic status flags.
+	FlagsStatus = FlagCF | FlagPF | FlagAF | FlagZF | FlagSF | FlagOF
+)
+
+var flagNames = []struct {
+	flag FlagSet
+	name string
+}{
+	{FlagCF, "CF"},
+	{FlagPF, "PF"},
+	{FlagAF, "AF"},
+	{FlagZF, "ZF"},
+	{FlagSF, "SF"},
+	{FlagTF, "TF"},
+	{FlagIF, "IF"},
+	{FlagDF, "DF"},
+	{FlagOF, "OF"},
+	{FlagIOPL, "IOPL"},
+	{FlagNT, "NT"},
+	{FlagRF, "RF"},
+	{FlagVM, "VM"},
+	{FlagAC, "AC"},
+	{FlagVIF, "VIF"},
+	{FlagVIP, "VIP"},
+	{FlagID, "ID"},
+	{FlagFC0, "FC0"},
+	{FlagFC1, "FC1"},
+	{FlagFC2, "FC2"},
+	{FlagFC3, "FC3"},
+}
+
+// Has reports whether all flags from x are present in fs.
+func (fs FlagSet) Has(x FlagSet) bool { return fs&x == x }
+
+// HasAny reports whether any of flags from x is present in fs.
+func (fs FlagSet) HasAny(x FlagSet) bool { return fs&x != 0 }
+
+// String returns "|"-separated flag names, like "CF|ZF".
+// Empty set is printed as "0".
+func (fs FlagSet) String() string {
+	if fs == 0 {
+		return "0"
+	}
+	var names []string
+	for _, f := range flagNames {
+		if fs.HasAny(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// FlagsInfo describes how instruction uses RFLAGS.
+type FlagsInfo struct {
+	read      FlagSet
+	written   FlagSet
+	undefined FlagSet
+}
+
+// Read returns flags that are read by instruction.
+func (info FlagsInfo) Read() FlagSet { return info.read }
+
+// Written returns flags that are written by instruction.
+func (info FlagsInfo) Written() FlagSet { return info.written }
+
+// Undefined returns flags that have undefined value after instruction execution.
+func (info FlagsInfo) Undefined() FlagSet { return info.undefined }
@@ -0,0 +1,75 @@
+package xedq
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestFlagSetString(t *testing.T) {
+	tests := map[FlagSet]string{
+		0:                     "0",
+		FlagCF:                "CF",
+		FlagCF | FlagZF:       "CF|ZF",
+		FlagIOPL | FlagFC3:    "IOPL|FC3",
+		FlagsStatus:           "CF|PF|AF|ZF|SF|OF",
+		FlagOF | FlagDF:       "DF|OF",
+		FlagsStatus &^ FlagCF: "PF|AF|ZF|SF|OF",
+	}
+
+	for fs, want := range tests {
+		if have := fs.String(); have != want {
+			t.Errorf("%#x: string mismatch:\nhave: %q\nwant: %q", uint32(fs), have, want)
+		}
+	}
+}
+
+func TestInstFlags(t *testing.T) {
+	encoder := NewEncoder(EncoderMode64)
+	decoder := encoder.Decoder()
+
+	tests := []struct {
+		encoding string
+		req      *EncodeRequest
+		read     FlagSet
+		written  FlagSet
+	}{
+		{"4801c8", encoder.Request("ADD").Reg("RAX").Reg("RCX"), 0, FlagsStatus},
+		{"4811c8", encoder.Request("ADC").Reg("RAX").Reg("RCX"), FlagCF, FlagsStatus},
+		{"48ffc0", encoder.Request("INC").Reg("RAX"), 0, FlagsStatus &^ FlagCF},
+		{"488d0409", encoder.Request("LEA").Reg("RAX").MemExpr(64, "RCX+RCX"), 0, 0},
+		{"4889c8", encoder.Request("MOV").Reg("RAX").Reg("RCX"), 0, 0},
+		{"7712", encoder.Request("JNBE").Rel8(0x12), FlagCF | FlagZF, 0},
+	}
+
+	for _, test := range tests {
+		code, _ := hex.DecodeString(test.encoding)
+		inst, err := decoder.Decode(code)
+		if err != nil {
+			t.Errorf("%q decoding error: %v", test.encoding, err)
+			continue
+		}
+
+		infos := map[string]FlagsInfo{
+			"decoded": inst.Flags(),
+			"request": test.req.Flags(),
+		}
+		if err := encoder.Err(); err != nil {
+			t.Errorf("%s: encoding error: %v", test.req, err)
+			continue
+		}
+		for kind, info := range infos {
+			if info.Read() != test.read {
+				t.Errorf("%q (%s): read flags mismatch:\nhave: %s\nwant: %s",
+					test.encoding, kind, info.Read(), test.read)
+			}
+			if info.Written() != test.written {
+				t.Errorf("%q (%s): written flags mismatch:\nhave: %s\nwant: %s",
+					test.encoding, kind, info.Written(), test.written)
+			}
+			if info.Undefined() != 0 {
+				t.Errorf("%q (%s): unexpected undefined flags: %s",
+					test.encoding, kind, info.Undefined())
+			}
+		}
+	}
+}
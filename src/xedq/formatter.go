@@ -0,0 +1,170 @@
+package xedq
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	errFormat = errors.New("formatter: instruction formatting failed")
+)
+
+// Syntax is an assembly language dialect.
+type Syntax uint8
+
+// Supported syntax dialects.
+const (
+	// SyntaxIntel is Intel (NASM/objdump -M intel like) syntax.
+	SyntaxIntel Syntax = iota
+
+	// SyntaxATT is AT&T (GNU as) syntax.
+	SyntaxATT
+
+	// SyntaxXED is XED-specific verbose syntax.
+	// Mostly useful for debugging.
+	SyntaxXED
+)
+
+func (syntax Syntax) String() string {
+	switch syntax {
+	case SyntaxIntel:
+		return "intel"
+	case SyntaxATT:
+		return "att"
+	case SyntaxXED:
+		return "xed"
+	default:
+		return "??"
+	}
+}
+
+// Formatter converts decoded instructions into assembly text.
+//
+// Zero value is a valid Intel syntax formatter.
+type Formatter struct {
+	// Syntax selects output dialect.
+	Syntax Syntax
+
+	// Uppercase makes mnemonics and register names upper case.
+	// By default, XED output letter case is used,
+	// which is lower case for Intel and AT&T syntax.
+	// Hex number prefix is always printed as "0x".
+	Uppercase bool
+
+	// RelativeBranches makes branch targets printed as offsets
+	// from the instruction start, like "$+0x12" or "$-0x4",
+	// instead of absolute addresses.
+	RelativeBranches bool
+
+	// Symbolizer is used to print branch targets and
+	// absolute or RIP-relative memory references as symbols.
+	// Nil Symbolizer disables symbolic output.
+	//
+	// With RelativeBranches, branch targets are not symbolized.
+	Symbolizer Symbolizer
+}
+
+// Symbolizer maps addresses to symbol names.
+type Symbolizer interface {
+	// Symbolize returns a name of the symbol that contains addr
+	// and addr offset from the symbol start.
+	// Returns false if addr is not associated with any known symbol.
+	Symbolize(addr uint64) (name string, offset uint64, ok bool)
+}
+
+// SymbolizerFunc is an adapter to allow the use of
+// ordinary functions as Symbolizer.
+type SymbolizerFunc func(addr uint64) (name string, offset uint64, ok bool)
+
+// Symbolize calls f(addr).
+func (f SymbolizerFunc) Symbolize(addr uint64) (string, uint64, bool) {
+	return f(addr)
+}
+
+// symbolizeContext holds single Format call symbolization state.
+type symbolizeContext struct {
+	sym Symbolizer
+
+	// Branch target address that should not be symbolized.
+	skip    uint64
+	hasSkip bool
+
+	// All names that were returned by sym.
+	names []string
+}
+
+func (ctx *symbolizeContext) symbolize(addr uint64) (string, uint64, bool) {
+	if ctx.hasSkip && addr == ctx.skip {
+		return "", 0, false
+	}
+	name, offset, ok := ctx.sym.Symbolize(addr)
+	if ok {
+		ctx.names = append(ctx.names, name)
+	}
+	return name, offset, ok
+}
+
+// Format returns textual representation of inst.
+//
+// addr is an address of the instruction.
+// It is used to print absolute branch targets.
+func (f *Formatter) Format(inst *DecodedInst, addr uint64) (string, error) {
+	var ctx *symbolizeContext
+	if f.Symbolizer != nil {
+		ctx = &symbolizeContext{sym: f.Symbolizer}
+		if f.RelativeBranches {
+			ctx.skip, ctx.hasSkip = branchTarget(inst, addr)
+		}
+	}
+
+	s, ok := xedFormat(f.Syntax, &inst.xedd, addr, ctx)
+	if !ok {
+		return "", errFormat
+	}
+
+	if f.RelativeBranches {
+		s = f.relativeBranch(inst, addr, s)
+	}
+	if f.Uppercase {
+		s = strings.Replace(strings.ToUpper(s), "0X", "0x", -1)
+		if ctx != nil {
+			// Symbol names should be printed as is.
+			for _, name := range ctx.names {
+				s = strings.Replace(s, strings.ToUpper(name), name, -1)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// relativeBranch replaces absolute branch target in s
+// with instruction-relative offset.
+// s is returned as is if it has no such target,
+// like when target is printed in other form.
+func (f *Formatter) relativeBranch(inst *DecodedInst, addr uint64, s string) string {
+	for _, op := range inst.Operands() {
+		if op.Kind != OperandRel {
+			continue
+		}
+		offset := int64(inst.Len()) + int64(op.Rel)
+		target := fmt.Sprintf("%#x", addr+uint64(offset))
+		rel := fmt.Sprintf("$+%#x", offset)
+		if offset < 0 {
+			rel = fmt.Sprintf("$-%#x", -offset)
+		}
+		// XED syntax prints suppressed operands after the target,
+		// so it's not necessarily the last argument.
+		args := strings.Split(s, " ")
+		for i, arg := range args {
+			arg = strings.TrimSuffix(arg, ",")
+			if arg == target || strings.HasSuffix(arg, ":"+target) {
+				args[i] = strings.Replace(args[i], target, rel, 1)
+				return strings.Join(args, " ")
+			}
+		}
+		return s
+	}
+	return s
+}
@@ -0,0 +1,119 @@
+package xedq
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestFormatter(t *testing.T) {
+	decoder := NewDecoder(DecoderMode64)
+
+	tests := []struct {
+		formatter Formatter
+		encoding  string
+		addr      uint64
+		want      string
+	}{
+		{Formatter{}, "4889c8", 0, "mov rax, rcx"},
+		{Formatter{}, "678b449144", 0, "mov eax, dword ptr [ecx+edx*4+0x44]"},
+		{Formatter{Syntax: SyntaxATT}, "4889c8", 0, "mov %rcx, %rax"},
+		{Formatter{Uppercase: true}, "4889c8", 0, "MOV RAX, RCX"},
+		{Formatter{Uppercase: true}, "0433", 0, "ADD AL, 0x33"},
+		{Formatter{}, "e834120000", 0x1000, "call 0x2239"},
+		{Formatter{RelativeBranches: true}, "e834120000", 0x1000, "call $+0x1239"},
+		{Formatter{RelativeBranches: true}, "ebfe", 0x1000, "jmp $+0x0"},
+		{Formatter{RelativeBranches: true}, "ebf0", 0, "jmp $-0xe"},
+	}
+
+	for _, test := range tests {
+		code, _ := hex.DecodeString(test.encoding)
+		inst, err := decoder.Decode(code)
+		if err != nil {
+			t.Errorf("%q decoding error: %v", test.encoding, err)
+			continue
+		}
+		have, err := test.formatter.Format(inst, test.addr)
+		if err != nil {
+			t.Errorf("%q formatting error: %v", test.encoding, err)
+			continue
+		}
+		if have != test.want {
+			t.Errorf("%q (%s) output mismatch:\nhave: %q\nwant: %q",
+				test.encoding, test.formatter.Syntax, have, test.want)
+		}
+	}
+
+	// XED syntax also prints suppressed operands, like RIP and RSP,
+	// only the branch target itself can be replaced.
+	code, _ := hex.DecodeString("e834120000")
+	inst, _ := decoder.Decode(code)
+	abs := Formatter{Syntax: SyntaxXED}
+	rel := Formatter{Syntax: SyntaxXED, RelativeBranches: true}
+	absOut, _ := abs.Format(inst, 0x1000)
+	relOut, _ := rel.Format(inst, 0x1000)
+	want := strings.Replace(absOut, "0x2239", "$+0x1239", 1)
+	if relOut != want {
+		t.Errorf("%s relative branch output mismatch:\nhave: %q\nwant: %q",
+			rel.Syntax, relOut, want)
+	}
+}
+
+func TestFormatterSymbolizer(t *testing.T) {
+	decoder := NewDecoder(DecoderMode64)
+
+	symbols := SymbolizerFunc(func(addr uint64) (string, uint64, bool) {
+		switch {
+		case addr >= 0x2000 && addr < 0x2100:
+			return "runtime.mallocgc", addr - 0x2000, true
+		case addr >= 0x5000 && addr < 0x5100:
+			return "main.counter", addr - 0x5000, true
+		default:
+			return "", 0, false
+		}
+	})
+
+	tests := []struct {
+		formatter Formatter
+		encoding  string
+		addr      uint64
+		want      string
+	}{
+		// call 0x2000
+		{Formatter{Symbolizer: symbols}, "e8fb0f0000", 0x1000, "runtime.mallocgc"},
+		{Formatter{Symbolizer: symbols, Uppercase: true}, "e8fb0f0000", 0x1000, "runtime.mallocgc"},
+		// call 0x2010
+		{Formatter{Symbolizer: symbols}, "e80b100000", 0x1000, "runtime.mallocgc+0x10"},
+		// mov eax, dword ptr [rip+0x3ffa] => 0x5000
+		{Formatter{Symbolizer: symbols}, "8b05fa3f0000", 0x1000, "main.counter"},
+		// call 0x3000: no symbol
+		{Formatter{Symbolizer: symbols}, "e8fb1f0000", 0x1000, "0x3000"},
+	}
+
+	for _, test := range tests {
+		code, _ := hex.DecodeString(test.encoding)
+		inst, err := decoder.Decode(code)
+		if err != nil {
+			t.Errorf("%q decoding error: %v", test.encoding, err)
+			continue
+		}
+		have, err := test.formatter.Format(inst, test.addr)
+		if err != nil {
+			t.Errorf("%q formatting error: %v", test.encoding, err)
+			continue
+		}
+		if !strings.Contains(have, test.want) {
+			t.Errorf("%q output %q does not contain %q",
+				test.encoding, have, test.want)
+		}
+	}
+
+	// Relative branches are not symbolized.
+	code, _ := hex.DecodeString("e8fb0f0000")
+	inst, _ := decoder.Decode(code)
+	f := Formatter{Symbolizer: symbols, RelativeBranches: true}
+	if have, _ := f.Format(inst, 0x1000); have != "call $+0x1000" {
+		t.Errorf("relative branch output mismatch:\nhave: %q\nwant: %q",
+			have, "call $+0x1000")
+	}
+}
@@ -16,7 +16,7 @@ var memScaleMap = map[string]uint8{
 // IntelMemExprParse parses Intel-like syntax for memory operands.
 // Implements MemExprParseFunc signature.
 //
-// expr can be in these forms:
+// expr can be in these forms, optionally prefixed by "SEG:":
 //   "BASE"
 //   "BASE±DISP"
 //   "BASE+INDEX"
@@ -25,7 +25,8 @@ var memScaleMap = map[string]uint8{
 //   "BASE+INDEX*SCALE±DISP"
 //   "INDEX*SCALE"
 //   "INDEX*SCALE±DISP"
-// BASE and INDEX are register names.
+//   "DISP"
+// SEG, BASE and INDEX are register names.
 // SCALE can be 1, 2, 4 or 8.
 // DISP is integer in decimal or hex format.
 // For hex, use "0x" prefix. Only lower case a-f letters are accepted.
@@ -33,6 +34,11 @@ var memScaleMap = map[string]uint8{
 func IntelMemExprParse(expr string) (Ptr, error) {
 	var ptr Ptr
 
+	if colonPos := strings.IndexByte(expr, ':'); colonPos != -1 {
+		ptr.Seg = expr[:colonPos]
+		expr = expr[colonPos+1:]
+	}
+
 	signPos := -1
 dispLoop:
 	for i := len(expr) - 1; i >= 0; i-- {
@@ -48,6 +54,11 @@ dispLoop:
 			break dispLoop
 		}
 	}
+	if signPos == -1 && expr != "" && expr[0] >= '0' && expr[0] <= '9' {
+		// Displacement-only expression, like for MOV moffs forms.
+		expr = "+" + expr
+		signPos = 0
+	}
 	if signPos != -1 {
 		dispBase := 10
 		dispExpr := expr[signPos+1:]
@@ -55,16 +66,24 @@ dispLoop:
 			dispExpr = dispExpr[len("0x"):]
 			dispBase = 16
 		}
-		disp, err := strconv.ParseInt(dispExpr, dispBase, 64)
+		// Parsed as unsigned to accept 64bit moffs addresses
+		// above MaxInt64, like 0xffffffffff600000.
+		disp, err := strconv.ParseUint(dispExpr, dispBase, 64)
 		if err != nil {
 			return ptr, errors.New("disp parse error: " + err.Error())
 		}
-		ptr.Disp = int32(disp)
+		ptr.Disp = int64(disp)
 		if expr[signPos] == '-' {
+			if disp > 1<<63 {
+				return ptr, errors.New("disp parse error: negative displacement overflows int64")
+			}
 			ptr.Disp = -ptr.Disp
 		}
 
 		expr = expr[:signPos]
+		if expr == "" {
+			return ptr, nil
+		}
 	}
 
 	indexPos := strings.IndexByte(expr, '+')
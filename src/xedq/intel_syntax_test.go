@@ -7,38 +7,51 @@ import (
 func TestIntelMemExprParse(t *testing.T) {
 	tests := map[string]Ptr{
 		// Base only.
-		"RCX":        {"RCX", "", 0, 0},
-		"R8":         {"R8", "", 0, 0},
-		"RCX+0":      {"RCX", "", 0, 0},
-		"RDX+8":      {"RDX", "", 0, 8},
-		"RCX+1750":   {"RCX", "", 0, 1750},
-		"RCX+0x0":    {"RCX", "", 0, 0},
-		"RCX+0xf0":   {"RCX", "", 0, 0xf0},
-		"RCX-0x01fa": {"RCX", "", 0, -0x1fa},
+		"RCX":        {"RCX", "", 0, 0, ""},
+		"R8":         {"R8", "", 0, 0, ""},
+		"RCX+0":      {"RCX", "", 0, 0, ""},
+		"RDX+8":      {"RDX", "", 0, 8, ""},
+		"RCX+1750":   {"RCX", "", 0, 1750, ""},
+		"RCX+0x0":    {"RCX", "", 0, 0, ""},
+		"RCX+0xf0":   {"RCX", "", 0, 0xf0, ""},
+		"RCX-0x01fa": {"RCX", "", 0, -0x1fa, ""},
 		// Index*Scale.
-		"RAX*2":        {"", "RAX", 2, 0},
-		"R9*8":         {"", "R9", 8, 0},
-		"RAX*2+0":      {"", "RAX", 2, 0},
-		"RAX*2+1750":   {"", "RAX", 2, 1750},
-		"RAX*2+0x0":    {"", "RAX", 2, 0},
-		"RAX*2+0xf0":   {"", "RAX", 2, 0xf0},
-		"RAX*2-0x01fa": {"", "RAX", 2, -0x1fa},
+		"RAX*2":        {"", "RAX", 2, 0, ""},
+		"R9*8":         {"", "R9", 8, 0, ""},
+		"RAX*2+0":      {"", "RAX", 2, 0, ""},
+		"RAX*2+1750":   {"", "RAX", 2, 1750, ""},
+		"RAX*2+0x0":    {"", "RAX", 2, 0, ""},
+		"RAX*2+0xf0":   {"", "RAX", 2, 0xf0, ""},
+		"RAX*2-0x01fa": {"", "RAX", 2, -0x1fa, ""},
 		// Base+Index.
-		"RAX+RCX":        {"RAX", "RCX", 0, 0},
-		"R9+R8":          {"R9", "R8", 0, 0},
-		"RAX+RCX+0":      {"RAX", "RCX", 0, 0},
-		"RAX+RCX+1750":   {"RAX", "RCX", 0, 1750},
-		"RAX+RCX+0x0":    {"RAX", "RCX", 0, 0},
-		"RAX+RCX+0xf0":   {"RAX", "RCX", 0, 0xf0},
-		"RAX+RCX-0x01fa": {"RAX", "RCX", 0, -0x1fa},
+		"RAX+RCX":        {"RAX", "RCX", 0, 0, ""},
+		"R9+R8":          {"R9", "R8", 0, 0, ""},
+		"RAX+RCX+0":      {"RAX", "RCX", 0, 0, ""},
+		"RAX+RCX+1750":   {"RAX", "RCX", 0, 1750, ""},
+		"RAX+RCX+0x0":    {"RAX", "RCX", 0, 0, ""},
+		"RAX+RCX+0xf0":   {"RAX", "RCX", 0, 0xf0, ""},
+		"RAX+RCX-0x01fa": {"RAX", "RCX", 0, -0x1fa, ""},
 		// Base+Index*Scale.
-		"RAX+RCX*4":        {"RAX", "RCX", 4, 0},
-		"R9+R8*1":          {"R9", "R8", 1, 0},
-		"RAX+RCX*4+0":      {"RAX", "RCX", 4, 0},
-		"RAX+RCX*4+1750":   {"RAX", "RCX", 4, 1750},
-		"RAX+RCX*4+0x0":    {"RAX", "RCX", 4, 0},
-		"RAX+RCX*4+0xf0":   {"RAX", "RCX", 4, 0xf0},
-		"RAX+RCX*4-0x01fa": {"RAX", "RCX", 4, -0x1fa},
+		"RAX+RCX*4":        {"RAX", "RCX", 4, 0, ""},
+		"R9+R8*1":          {"R9", "R8", 1, 0, ""},
+		"RAX+RCX*4+0":      {"RAX", "RCX", 4, 0, ""},
+		"RAX+RCX*4+1750":   {"RAX", "RCX", 4, 1750, ""},
+		"RAX+RCX*4+0x0":    {"RAX", "RCX", 4, 0, ""},
+		"RAX+RCX*4+0xf0":   {"RAX", "RCX", 4, 0xf0, ""},
+		"RAX+RCX*4-0x01fa": {"RAX", "RCX", 4, -0x1fa, ""},
+		// Disp only.
+		"1750":               {"", "", 0, 1750, ""},
+		"0xf0":               {"", "", 0, 0xf0, ""},
+		"0x0123456789abcdef": {"", "", 0, 0x0123456789abcdef, ""},
+		"0xffffffffff600000": {"", "", 0, -0xa00000, ""},
+		// VSIB.
+		"RAX+ZMM3*4":      {"RAX", "ZMM3", 4, 0, ""},
+		"YMM1*8+0x10":     {"", "YMM1", 8, 0x10, ""},
+		"R8+XMM15*1-0x40": {"R8", "XMM15", 1, -0x40, ""},
+		// Segment override.
+		"FS:0x28":         {"", "", 0, 0x28, "FS"},
+		"GS:RAX+8":        {"RAX", "", 0, 8, "GS"},
+		"FS:RAX+RCX*4-16": {"RAX", "RCX", 4, -16, "FS"},
 	}
 
 	for expr, want := range tests {
@@ -8,18 +8,26 @@ import (
 // Functions that are hard to categorize or associate with any
 // other source file.
 
-func memExprString(req *EncodeRequest) string {
+func memExprString(width uint16, ptr Ptr) string {
 	var buf bytes.Buffer
 
-	base := req.ptr.Base
-	index := req.ptr.Index
-	scale := req.ptr.Scale
-	disp := req.ptr.Disp
+	base := ptr.Base
+	index := ptr.Index
+	scale := ptr.Scale
+	disp := ptr.Disp
 
-	fmt.Fprintf(&buf, "mem%d", req.memWidth)
+	fmt.Fprintf(&buf, "mem%d", width)
 
 	buf.WriteByte('[')
+	if ptr.Seg != "" {
+		buf.WriteString(ptr.Seg + ":")
+	}
 	switch {
+	case base == "" && index == "":
+		// Displacement only.
+		fmt.Fprintf(&buf, "%#x", disp)
+		buf.WriteByte(']')
+		return buf.String()
 	case index == "" && scale == 0:
 		fmt.Fprintf(&buf, "%s", base)
 	case base == "" && index != "" && scale != 0:
@@ -3,10 +3,17 @@ package xedq
 /*
 #cgo LDFLAGS: -lxed
 #include <xed/xed-interface.h>
+
+extern int xedqSymbolize(xed_uint64_t addr, char* buf, xed_uint32_t bufLen, xed_uint64_t* offset, void* ctx);
+
+// Defined in xed_prefixes.c, as this preamble can only have declarations.
+extern void xedqSetPrefixes(xed_encoder_instruction_t* x, int lock, int rep, int repne, int taken, int notTaken);
 */
 import "C"
 
 import (
+	"math"
+	"runtime/cgo"
 	"unsafe"
 )
 
@@ -33,10 +40,25 @@ const (
 const (
 	xedRegInvalid    = xedRegister(C.XED_REG_INVALID)
 	xedIclassInvalid = xedIclass(C.XED_ICLASS_INVALID)
+	xedChipInvalid   = xedChip(C.XED_CHIP_INVALID)
+)
+
+const (
+	// Upper limit for single instruction encoding length.
+	xedMaxInstBytes = C.XED_MAX_INSTRUCTION_BYTES
+
+	// Upper limit for XED encoder operands count,
+	// including EVEX modifiers.
+	xedMaxOperands = C.XED_ENCODER_OPERANDS_MAX
+
+	// Should be big enough to hold any formatted instruction.
+	xedFormatBufSize = 256
 )
 
 var (
-	errEmpty = xedError(C.XED_ERROR_NONE)
+	errEmpty          = xedError(C.XED_ERROR_NONE)
+	errBufTooShort    = xedError(C.XED_ERROR_BUFFER_TOO_SHORT)
+	errInvalidForChip = xedError(C.XED_ERROR_INVALID_FOR_CHIP)
 )
 
 // String returns reg name.
@@ -44,19 +66,47 @@ func (reg xedRegister) String() string {
 	return C.GoString(C.xed_reg_enum_t2str(C.xed_reg_enum_t(reg)))
 }
 
+// Width returns reg size in bits.
+// Returns 0 for invalid register.
+func (reg xedRegister) Width() int {
+	return int(C.xed_get_register_width_bits64(C.xed_reg_enum_t(reg)))
+}
+
 func xedTablesInit() { C.xed_tables_init() }
 
 type (
-	xedState  C.xed_state_t
-	xedInst   C.xed_encoder_instruction_t
-	xedIclass C.xed_iclass_enum_t
-	xedError  C.xed_error_enum_t
+	xedState       C.xed_state_t
+	xedInst        C.xed_encoder_instruction_t
+	xedDecodedInst C.xed_decoded_inst_t
+	xedIclass      C.xed_iclass_enum_t
+	xedChip        C.xed_chip_enum_t
+	xedError       C.xed_error_enum_t
 )
 
 func (inst *xedInst) CPtr() *C.xed_encoder_instruction_t {
 	return (*C.xed_encoder_instruction_t)(inst)
 }
 
+func (xedd *xedDecodedInst) CPtr() *C.xed_decoded_inst_t {
+	return (*C.xed_decoded_inst_t)(xedd)
+}
+
+func newXEDState16() xedState {
+	var state C.xed_state_t
+	C.xed_state_zero(&state)
+	state.stack_addr_width = C.XED_ADDRESS_WIDTH_16b
+	state.mmode = C.XED_MACHINE_MODE_LEGACY_16
+	return xedState(state)
+}
+
+func newXEDStateReal16() xedState {
+	var state C.xed_state_t
+	C.xed_state_zero(&state)
+	state.stack_addr_width = C.XED_ADDRESS_WIDTH_16b
+	state.mmode = C.XED_MACHINE_MODE_REAL_16
+	return xedState(state)
+}
+
 func newXEDState32() xedState {
 	var state C.xed_state_t
 	C.xed_state_zero(&state)
@@ -82,6 +132,22 @@ func (state xedState) CValue() C.xed_state_t {
 	return C.xed_state_t(state)
 }
 
+func (state *xedState) CPtr() *C.xed_state_t {
+	return (*C.xed_state_t)(state)
+}
+
+// AddrWidth returns default address size in bits.
+func (state *xedState) AddrWidth() int {
+	switch state.mmode {
+	case C.XED_MACHINE_MODE_LONG_64:
+		return 64
+	case C.XED_MACHINE_MODE_LEGACY_32:
+		return 32
+	default:
+		return 16
+	}
+}
+
 func newXEDState64() xedState {
 	var state C.xed_state_t
 	C.xed_state_zero(&state)
@@ -98,12 +164,77 @@ func (iclass xedIclass) CValue() C.xed_iclass_enum_t {
 	return C.xed_iclass_enum_t(iclass)
 }
 
+// IsVSIB reports whether iclass memory operand uses VSIB addressing,
+// which is true for gather and scatter instructions.
+// All iclass forms are checked, as VEX and EVEX gathers
+// have different categories.
+func (iclass xedIclass) IsVSIB() bool {
+	first := C.xed_iform_first_per_iclass(iclass.CValue())
+	count := C.xed_iform_max_per_iclass(iclass.CValue())
+	for i := first; i < first+count; i++ {
+		switch C.xed_iform_to_category(C.xed_iform_enum_t(i)) {
+		case C.XED_CATEGORY_GATHER, C.XED_CATEGORY_AVX2GATHER, C.XED_CATEGORY_SCATTER:
+			return true
+		}
+	}
+	return false
+}
+
+// HasEVEXForm reports whether iclass can be encoded with EVEX.
+func (iclass xedIclass) HasEVEXForm() bool {
+	first := C.xed_iform_first_per_iclass(iclass.CValue())
+	count := C.xed_iform_max_per_iclass(iclass.CValue())
+	for i := first; i < first+count; i++ {
+		if C.xed_iform_to_extension(C.xed_iform_enum_t(i)) == C.XED_EXTENSION_AVX512EVEX {
+			return true
+		}
+	}
+	return false
+}
+
+// RepPrefix returns prefixRep or prefixRepne if iclass
+// is a REP/REPE or REPNE form, like REP_MOVSB.
+// Returns 0 for other iclasses.
+func (iclass xedIclass) RepPrefix() legacyPrefixes {
+	norep := C.xed_norep_map(iclass.CValue())
+	switch {
+	case norep == C.XED_ICLASS_INVALID || norep == iclass.CValue():
+		return 0
+	case C.xed_repne_map(norep) == iclass.CValue():
+		return prefixRepne
+	default:
+		return prefixRep
+	}
+}
+
+// RepForm returns iclass REP/REPE form, or REPNE form if repne is true.
+// Returns xedIclassInvalid if there is no such form.
+func (iclass xedIclass) RepForm(repne bool) xedIclass {
+	if repne {
+		return xedIclass(C.xed_repne_map(iclass.CValue()))
+	}
+	rep := C.xed_rep_map(iclass.CValue())
+	if rep == C.XED_ICLASS_INVALID {
+		rep = C.xed_repe_map(iclass.CValue())
+	}
+	return xedIclass(rep)
+}
+
 func newXEDIclass(name string, tmpbuf *buffer) xedIclass {
 	tmpbuf.SetCString(name)
 	iclass := C.str2xed_iclass_enum_t(tmpbuf.CString())
 	return xedIclass(iclass)
 }
 
+func (chip xedChip) String() string {
+	return C.GoString(C.xed_chip_enum_t2str(C.xed_chip_enum_t(chip)))
+}
+
+func newXEDChip(name string, tmpbuf *buffer) xedChip {
+	tmpbuf.SetCString(name)
+	return xedChip(C.str2xed_chip_enum_t(tmpbuf.CString()))
+}
+
 func newXEDInst(state *xedState, req *EncodeRequest) xedInst {
 	var inst C.xed_encoder_instruction_t
 
@@ -121,54 +252,58 @@ func newXEDInst(state *xedState, req *EncodeRequest) xedInst {
 		eosz = 64
 	default:
 		//TODO: try to set with respect to DF64 and DF32.
-		eosz = 32
+		if state.AddrWidth() == 16 {
+			eosz = 16
+		} else {
+			eosz = 32
+		}
 	}
 
 	// It is possible to initialize inst operands directly,
-	// but that is more likely to break than xed_instN API,
+	// but that is more likely to break than xed_inst API,
 	// which is explicitly public.
-	switch req.argc {
-	default:
-		panic("unexpected args count")
-	case 0:
-		C.xed_inst0(&inst, state.CValue(), iclass.CValue(), eosz)
-	case 1:
-		C.xed_inst1(&inst, state.CValue(), iclass.CValue(), eosz,
-			xedOperand(req, 0))
-	case 2:
-		C.xed_inst2(&inst, state.CValue(), iclass.CValue(), eosz,
-			xedOperand(req, 0),
-			xedOperand(req, 1))
-	case 3:
-		C.xed_inst3(&inst, state.CValue(), iclass.CValue(), eosz,
-			xedOperand(req, 0),
-			xedOperand(req, 1),
-			xedOperand(req, 2))
-	case 4:
-		C.xed_inst4(&inst, state.CValue(), iclass.CValue(), eosz,
-			xedOperand(req, 0),
-			xedOperand(req, 1),
-			xedOperand(req, 2),
-			xedOperand(req, 3))
-	case 5:
-		C.xed_inst5(&inst, state.CValue(), iclass.CValue(), eosz,
-			xedOperand(req, 0),
-			xedOperand(req, 1),
-			xedOperand(req, 2),
-			xedOperand(req, 3),
-			xedOperand(req, 4))
+	var ops [xedMaxOperands]C.xed_encoder_operand_t
+	n := 0
+	for i := 0; i < int(req.argc); i++ {
+		ops[n] = xedOperand(state, req, i)
+		n++
+	}
+	n += xedEVEXOperands(req, ops[n:])
+	C.xed_inst(&inst, state.CValue(), iclass.CValue(), eosz, C.xed_uint_t(n), &ops[0])
+
+	if addrWidth := req.addrWidth(); addrWidth != 0 {
+		C.xed_addr(&inst, C.xed_uint_t(addrWidth))
+	}
+
+	if p := req.prefixes; p != 0 {
+		// HLE prefixes are encoded as REP (XRELEASE) and REPNE (XACQUIRE).
+		C.xedqSetPrefixes(&inst,
+			cbool(p&prefixLock != 0),
+			cbool(p&(prefixRep|prefixXrelease) != 0),
+			cbool(p&(prefixRepne|prefixXacquire) != 0),
+			cbool(p&prefixHintTaken != 0),
+			cbool(p&prefixHintNotTaken != 0))
 	}
 
 	return xedInst(inst)
 }
 
-func xedEncode(inst *xedInst, dstbuf *buffer) (int, error) {
+// xedEncode encodes inst into dstbuf.
+// formEVEX and formREX forms are passed to XED as encoder knobs,
+// other forms are ignored.
+func xedEncode(inst *xedInst, forms encodingForms, dstbuf *buffer) (int, error) {
 	var req C.xed_encoder_request_t
 	C.xed_encoder_request_zero_set_mode(&req, &inst.mode)
 	ok := C.xed_convert_to_encoder_request(&req, inst.CPtr())
 	if ok == 0 {
 		return 0, errEncReqConvert
 	}
+	if forms&formEVEX != 0 {
+		C.xed3_operand_set_must_use_evex(&req, 1)
+	}
+	if forms&formREX != 0 {
+		C.xed3_operand_set_rex(&req, 1)
+	}
 
 	codeLen := C.uint(0)
 	err := xedError(C.xed_encode(
@@ -184,47 +319,478 @@ func xedEncode(inst *xedInst, dstbuf *buffer) (int, error) {
 	return int(codeLen), nil
 }
 
-func xedMemOperand(req *EncodeRequest, bitSize int) C.xed_encoder_operand_t {
+// xedDecode decodes single instruction from code into xedd.
+// If chip is not xedChipInvalid, instructions that are
+// not supported by that chip are rejected.
+//
+// xedd keeps a reference to code, so caller should
+// keep code alive (and unmodified) as long as xedd is used.
+func xedDecode(state *xedState, chip xedChip, code []byte, xedd *xedDecodedInst) error {
+	if len(code) == 0 {
+		return errBufTooShort
+	}
+	C.xed_decoded_inst_zero_set_mode(xedd.CPtr(), state.CPtr())
+	if chip != xedChipInvalid {
+		C.xed_decoded_inst_set_input_chip(xedd.CPtr(), C.xed_chip_enum_t(chip))
+	}
+	err := xedError(C.xed_decode(
+		xedd.CPtr(),
+		(*C.xed_uint8_t)(unsafe.Pointer(&code[0])),
+		C.uint(len(code)),
+	))
+	if !err.Empty() {
+		return err
+	}
+	return nil
+}
+
+// xedInstLen is like xedDecode, but only finds instruction length
+// using XED instruction length decoder (ILD).
+// xedd is used as a scratch space.
+func xedInstLen(state *xedState, code []byte, xedd *xedDecodedInst) (int, error) {
+	if len(code) == 0 {
+		return 0, errBufTooShort
+	}
+	C.xed_decoded_inst_zero_set_mode(xedd.CPtr(), state.CPtr())
+	err := xedError(C.xed_ild_decode(
+		xedd.CPtr(),
+		(*C.xed_uint8_t)(unsafe.Pointer(&code[0])),
+		C.uint(len(code)),
+	))
+	if !err.Empty() {
+		return 0, err
+	}
+	return xedd.Len(), nil
+}
+
+func (xedd *xedDecodedInst) Iclass() xedIclass {
+	return xedIclass(C.xed_decoded_inst_get_iclass(xedd.CPtr()))
+}
+
+func (xedd *xedDecodedInst) Iform() string {
+	iform := C.xed_decoded_inst_get_iform_enum(xedd.CPtr())
+	return C.GoString(C.xed_iform_enum_t2str(iform))
+}
+
+func (xedd *xedDecodedInst) Len() int {
+	return int(C.xed_decoded_inst_get_length(xedd.CPtr()))
+}
+
+func (xedd *xedDecodedInst) Category() string {
+	category := C.xed_decoded_inst_get_category(xedd.CPtr())
+	return C.GoString(C.xed_category_enum_t2str(category))
+}
+
+func (xedd *xedDecodedInst) Extension() string {
+	ext := C.xed_decoded_inst_get_extension(xedd.CPtr())
+	return C.GoString(C.xed_extension_enum_t2str(ext))
+}
+
+func (xedd *xedDecodedInst) IsaSet() string {
+	isaSet := C.xed_decoded_inst_get_isa_set(xedd.CPtr())
+	return C.GoString(C.xed_isa_set_enum_t2str(isaSet))
+}
+
+// Attributes returns names of all attributes that are set for xedd.
+func (xedd *xedDecodedInst) Attributes() []string {
+	var attrs []string
+	for i := C.XED_ATTRIBUTE_INVALID + 1; i < C.XED_ATTRIBUTE_LAST; i++ {
+		attr := C.xed_attribute_enum_t(i)
+		if C.xed_decoded_inst_get_attribute(xedd.CPtr(), attr) != 0 {
+			attrs = append(attrs, C.GoString(C.xed_attribute_enum_t2str(attr)))
+		}
+	}
+	return attrs
+}
+
+// CPL returns the lowest privilege level that is required to execute xedd.
+func (xedd *xedDecodedInst) CPL() int {
+	xi := C.xed_decoded_inst_inst(xedd.CPtr())
+	return int(C.xed_inst_cpl(xi))
+}
+
+// HintPrefixes returns xedd HLE and branch hint prefixes.
+func (xedd *xedDecodedInst) HintPrefixes() legacyPrefixes {
+	p := xedd.CPtr()
+	var prefixes legacyPrefixes
+	if C.xed_decoded_inst_is_xacquire(p) != 0 {
+		prefixes |= prefixXacquire
+	}
+	if C.xed_decoded_inst_is_xrelease(p) != 0 {
+		prefixes |= prefixXrelease
+	}
+	if C.xed_operand_values_branch_taken_hint(p) != 0 {
+		prefixes |= prefixHintTaken
+	}
+	if C.xed_operand_values_branch_not_taken_hint(p) != 0 {
+		prefixes |= prefixHintNotTaken
+	}
+	return prefixes
+}
+
+// EVEX returns xedd AVX-512 modifiers.
+// sae is only reported if there is no embedded rounding.
+func (xedd *xedDecodedInst) EVEX() (zeroing, broadcast bool, rounding roundingMode, sae bool) {
+	p := xedd.CPtr()
+	zeroing = C.xed3_operand_get_zeroing(p) != 0
+	broadcast = C.xed_decoded_inst_is_broadcast(p) != 0
+	rounding = roundingMode(C.xed3_operand_get_roundc(p))
+	sae = rounding == roundingDefault && C.xed3_operand_get_sae(p) != 0
+	return zeroing, broadcast, rounding, sae
+}
+
+// Easz returns instruction effective address size in bits.
+func (xedd *xedDecodedInst) Easz() int {
+	return int(C.xed_operand_values_get_effective_address_width(xedd.CPtr()))
+}
+
+// NumMemOperands returns the number of memory operands,
+// including implicit ones.
+func (xedd *xedDecodedInst) NumMemOperands() int {
+	return int(C.xed_decoded_inst_number_of_memory_operands(xedd.CPtr()))
+}
+
+// Eosz returns instruction effective operand size in bits.
+func (xedd *xedDecodedInst) Eosz() int {
+	return int(C.xed_decoded_inst_get_operand_width(xedd.CPtr()))
+}
+
+// ImmWidth returns the first immediate operand width in bits
+// and its signedness.
+func (xedd *xedDecodedInst) ImmWidth() (int, bool) {
+	p := xedd.CPtr()
+	width := int(C.xed_decoded_inst_get_immediate_width_bits(p))
+	return width, C.xed_decoded_inst_get_immediate_is_signed(p) != 0
+}
+
+// RelWidth returns branch displacement width in bits.
+func (xedd *xedDecodedInst) RelWidth() int {
+	return int(C.xed_decoded_inst_get_branch_displacement_width_bits(xedd.CPtr()))
+}
+
+// DispWidth returns memory operand displacement width in bits.
+func (xedd *xedDecodedInst) DispWidth(memIndex int) int {
+	p := xedd.CPtr()
+	return int(C.xed_decoded_inst_get_memory_displacement_width_bits(p, C.uint(memIndex)))
+}
+
+// Disp returns memory operand displacement.
+// For EVEX disp8*N it is already scaled by N.
+func (xedd *xedDecodedInst) Disp(memIndex int) int64 {
+	p := xedd.CPtr()
+	return int64(C.xed_decoded_inst_get_memory_displacement(p, C.uint(memIndex)))
+}
+
+// VexValid returns instruction encoding space:
+// 0 for legacy, 1 for VEX, 2 for EVEX and 3 for XOP.
+func (xedd *xedDecodedInst) VexValid() int {
+	return int(C.xed3_operand_get_vexvalid(xedd.CPtr()))
+}
+
+// HasREX reports whether instruction has REX prefix.
+func (xedd *xedDecodedInst) HasREX() bool {
+	return C.xed3_operand_get_rex(xedd.CPtr()) != 0
+}
+
+// Flags returns instruction RFLAGS read, written and undefined sets.
+func (xedd *xedDecodedInst) Flags() (read, written, undefined FlagSet) {
+	p := xedd.CPtr()
+	if C.xed_decoded_inst_uses_rflags(p) == 0 {
+		return 0, 0, 0
+	}
+	// XED flag set layout matches RFLAGS register,
+	// so the mask can be converted to FlagSet directly.
+	sf := C.xed_decoded_inst_get_rflags_info(p)
+	read = FlagSet(C.xed_flag_set_mask(C.xed_simple_flag_get_read_flag_set(sf)))
+	written = FlagSet(C.xed_flag_set_mask(C.xed_simple_flag_get_written_flag_set(sf)))
+	undefined = FlagSet(C.xed_flag_set_mask(C.xed_simple_flag_get_undefined_flag_set(sf)))
+	return read, written, undefined
+}
+
+// NumOperands returns the number of all instruction operands,
+// including implicit and suppressed ones.
+func (xedd *xedDecodedInst) NumOperands() int {
+	xi := C.xed_decoded_inst_inst(xedd.CPtr())
+	return int(C.xed_inst_noperands(xi))
+}
+
+// Operand returns i-th instruction operand.
+func (xedd *xedDecodedInst) Operand(i int) Operand {
+	p := xedd.CPtr()
+	xi := C.xed_decoded_inst_inst(p)
+	xop := C.xed_inst_operand(xi, C.uint(i))
+	name := C.xed_operand_name(xop)
+
+	op := Operand{
+		Name:  C.GoString(C.xed_operand_enum_t2str(name)),
+		Width: uint16(C.xed_decoded_inst_operand_length_bits(p, C.uint(i))),
+	}
+
+	switch C.xed_operand_operand_visibility(xop) {
+	case C.XED_OPVIS_IMPLICIT:
+		op.Visibility = OperandImplicit
+	case C.XED_OPVIS_SUPPRESSED:
+		op.Visibility = OperandSuppressed
+	default:
+		op.Visibility = OperandExplicit
+	}
+
+	switch C.xed_decoded_inst_operand_action(p, C.uint(i)) {
+	case C.XED_OPERAND_ACTION_RW:
+		op.Action = ActionReadWrite
+	case C.XED_OPERAND_ACTION_W:
+		op.Action = ActionWrite
+	case C.XED_OPERAND_ACTION_RCW:
+		op.Action = ActionReadCondWrite
+	case C.XED_OPERAND_ACTION_CW:
+		op.Action = ActionCondWrite
+	case C.XED_OPERAND_ACTION_CRW:
+		op.Action = ActionCondReadWrite
+	case C.XED_OPERAND_ACTION_CR:
+		op.Action = ActionCondRead
+	default:
+		op.Action = ActionRead
+	}
+
+	switch {
+	case name == C.XED_OPERAND_MEM0:
+		op.Kind = OperandMem
+		op.Mem, op.MemWidth = xedDecodedMem(p, 0)
+	case name == C.XED_OPERAND_AGEN:
+		// Address generation does not access memory,
+		// so there is no meaningful width.
+		op.Kind = OperandMem
+		op.Mem, _ = xedDecodedMem(p, 0)
+	case name == C.XED_OPERAND_MEM1:
+		op.Kind = OperandMem
+		op.Mem, op.MemWidth = xedDecodedMem(p, 1)
+	case name == C.XED_OPERAND_IMM0:
+		op.Kind = OperandImm
+		if C.xed_decoded_inst_get_immediate_is_signed(p) != 0 {
+			op.Imm = uint64(int64(C.xed_decoded_inst_get_signed_immediate(p)))
+		} else {
+			op.Imm = uint64(C.xed_decoded_inst_get_unsigned_immediate(p))
+		}
+	case name == C.XED_OPERAND_IMM1:
+		op.Kind = OperandImm
+		op.Imm = uint64(C.xed_decoded_inst_get_second_immediate(p))
+	case name == C.XED_OPERAND_RELBR:
+		op.Kind = OperandRel
+		op.Rel = int32(C.xed_decoded_inst_get_branch_displacement(p))
+	case C.xed_operand_is_register(name) != 0,
+		C.xed_operand_is_memory_addressing_register(name) != 0:
+		op.Kind = OperandReg
+		op.Reg = xedRegName(C.xed_decoded_inst_get_reg(p, name))
+	default:
+		op.Kind = OperandOther
+	}
+
+	return op
+}
+
+func xedDecodedMem(p *C.xed_decoded_inst_t, memIndex int) (Ptr, uint16) {
+	i := C.uint(memIndex)
+	ptr := Ptr{
+		Base:  xedRegName(C.xed_decoded_inst_get_base_reg(p, i)),
+		Index: xedRegName(C.xed_decoded_inst_get_index_reg(p, i)),
+		Disp:  int64(C.xed_decoded_inst_get_memory_displacement(p, i)),
+	}
+	if ptr.Index != "" {
+		ptr.Scale = uint8(C.xed_decoded_inst_get_scale(p, i))
+	}
+	// Only report explicit segment overrides,
+	// so default segments do not leak into Ptr.
+	if C.xed_operand_values_has_segment_prefix(p) != 0 {
+		seg := C.xed_decoded_inst_get_seg_reg(p, i)
+		if seg == C.xed_operand_values_segment_prefix(p) {
+			ptr.Seg = xedRegName(seg)
+		}
+	}
+	width := C.xed_decoded_inst_get_memory_operand_length(p, i) * 8
+	return ptr, uint16(width)
+}
+
+// xedFormat prints xedd using specified syntax.
+// addr is instruction runtime address that is used to
+// compute branch targets.
+// If ctx is not nil, it is used to print symbolic addresses.
+// Returns false if XED failed to format xedd.
+func xedFormat(syntax Syntax, xedd *xedDecodedInst, addr uint64, ctx *symbolizeContext) (string, bool) {
+	var xedSyntax C.xed_syntax_enum_t
+	switch syntax {
+	case SyntaxATT:
+		xedSyntax = C.XED_SYNTAX_ATT
+	case SyntaxXED:
+		xedSyntax = C.XED_SYNTAX_XED
+	default:
+		xedSyntax = C.XED_SYNTAX_INTEL
+	}
+
+	// Context can't be passed to C directly as it contains Go pointers.
+	// Pass a handle instead, xedqSymbolize resolves it back.
+	var handle cgo.Handle
+	var callback C.xed_disassembly_callback_fn_t
+	if ctx != nil {
+		handle = cgo.NewHandle(ctx)
+		defer handle.Delete()
+		callback = C.xed_disassembly_callback_fn_t(C.xedqSymbolize)
+	}
+
+	var buf [xedFormatBufSize]C.char
+	ok := C.xed_format_context(
+		xedSyntax,
+		xedd.CPtr(),
+		&buf[0],
+		C.int(len(buf)),
+		C.xed_uint64_t(addr),
+		unsafe.Pointer(&handle),
+		callback,
+	)
+	if ok == 0 {
+		return "", false
+	}
+	return C.GoString(&buf[0]), true
+}
+
+// xedqSymbolize is a XED disassembly callback that
+// forwards symbol lookups to the symbolizeContext.
+//
+//export xedqSymbolize
+func xedqSymbolize(addr C.xed_uint64_t, buf *C.char, bufLen C.xed_uint32_t, offset *C.xed_uint64_t, ctx unsafe.Pointer) C.int {
+	sc := (*cgo.Handle)(ctx).Value().(*symbolizeContext)
+	name, off, ok := sc.symbolize(uint64(addr))
+	if !ok || bufLen == 0 {
+		return 0
+	}
+	// Copy name as NUL-terminated string, truncating if necessary.
+	dst := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(bufLen))
+	n := copy(dst[:len(dst)-1], name)
+	dst[n] = 0
+	*offset = C.xed_uint64_t(off)
+	return 1
+}
+
+// xedRegName is like xedRegister.String, but returns
+// empty string for invalid register.
+func xedRegName(reg C.xed_reg_enum_t) string {
+	if reg == C.XED_REG_INVALID {
+		return ""
+	}
+	return xedRegister(reg).String()
+}
+
+func xedMemOperand(state *xedState, req *EncodeRequest, index int) C.xed_encoder_operand_t {
+	mem, memIndex := req.memAt(index)
+	addrWidth := req.addrWidth()
+	if addrWidth == 0 {
+		addrWidth = state.AddrWidth()
+	}
+	value := dispValue(mem.ptr.Disp, addrWidth)
 	var disp C.xed_enc_displacement_t
-	disp.displacement = C.xed_uint64_t(req.ptr.Disp)
-	switch req.dispWidth {
+	disp.displacement = C.xed_uint64_t(value)
+	dispWidth := req.dispWidth
+	if memIndex != 0 {
+		// Displacement width setting is only for the MEM0.
+		dispWidth = 0
+	}
+	if mem.ptr.Base == "RIP" || mem.ptr.Base == "EIP" {
+		// RIP-relative addressing only has disp32 form.
+		if dispWidth == 0 {
+			dispWidth = 32
+		}
+	}
+	switch dispWidth {
 	case 8:
 		disp.displacement_bits = 8
+	case 16:
+		disp.displacement_bits = 16
 	case 32:
 		disp.displacement_bits = 32
+	case 64:
+		disp.displacement_bits = 64
 	default:
-		if req.ptr.Disp == 0 {
+		noRegs := mem.ptr.Base == "" && mem.ptr.Index == ""
+		if noRegs && addrWidth == 64 && (value < math.MinInt32 || value > math.MaxInt32) {
+			// Only 64bit absolute addresses (MOV moffs forms) use disp64.
+			disp.displacement_bits = 64
+		} else if mem.ptr.Base == "" && addrWidth == 16 {
+			// 16bit addressing forms without base require disp16.
+			disp.displacement_bits = 16
+		} else if mem.ptr.Base == "" {
+			// Addressing forms without base require disp32.
+			disp.displacement_bits = 32
+		} else if value == 0 {
 			disp.displacement_bits = 0
-		} else if req.ptr.Disp >= -128 && req.ptr.Disp <= 127 {
+		} else if value >= -128 && value <= 127 {
 			disp.displacement_bits = 8
+		} else if addrWidth == 16 {
+			disp.displacement_bits = 16
 		} else {
 			disp.displacement_bits = 32
 		}
 	}
-	base := registerByName[req.ptr.Base]
-	index := registerByName[req.ptr.Index]
-	return C.xed_mem_bisd(
+	seg := registerByName[mem.ptr.Seg]
+	base := registerByName[mem.ptr.Base]
+	indexReg := registerByName[mem.ptr.Index]
+	return C.xed_mem_gbisd(
+		C.xed_reg_enum_t(seg),
 		C.xed_reg_enum_t(base),
-		C.xed_reg_enum_t(index),
-		C.xed_uint_t(req.ptr.Scale),
+		C.xed_reg_enum_t(indexReg),
+		C.xed_uint_t(mem.ptr.Scale),
 		disp,
-		C.xed_uint_t(bitSize))
+		C.xed_uint_t(mem.width))
 }
 
-func xedOperand(req *EncodeRequest, index int) C.xed_encoder_operand_t {
+// xedEVEXOperands fills ops with req AVX-512 modifiers.
+// Returns the number of filled operands.
+func xedEVEXOperands(req *EncodeRequest, ops []C.xed_encoder_operand_t) int {
+	n := 0
+	if req.zeroing {
+		ops[n] = C.xed_other(C.XED_OPERAND_ZEROING, 1)
+		n++
+	}
+	if req.broadcast || req.sae || req.rounding != roundingDefault {
+		// EVEX.b bit, which means broadcast for memory forms
+		// and rounding or SAE for register forms.
+		ops[n] = C.xed_other(C.XED_OPERAND_BCRC, 1)
+		n++
+	}
+	if req.rounding != roundingDefault {
+		ops[n] = C.xed_other(C.XED_OPERAND_ROUNDC, C.xed_int32_t(req.rounding))
+		n++
+	}
+	if req.rounding != roundingDefault || req.sae {
+		// Embedded rounding implies SAE.
+		ops[n] = C.xed_other(C.XED_OPERAND_SAE, 1)
+		n++
+	}
+	return n
+}
+
+func xedOperand(state *xedState, req *EncodeRequest, index int) C.xed_encoder_operand_t {
+	imm, second := req.immAt(index)
+	if second && req.tags[index].isImm() {
+		// Second immediate is always 8bit.
+		return C.xed_imm1(C.xed_uint8_t(imm))
+	}
+
 	switch req.tags[index] {
 	case argUint8:
-		return C.xed_imm0(C.xed_uint64_t(req.imm), 8)
+		return C.xed_imm0(C.xed_uint64_t(imm), 8)
+	case argUint16:
+		return C.xed_imm0(C.xed_uint64_t(imm), 16)
 	case argUint32:
-		return C.xed_imm0(C.xed_uint64_t(req.imm), 32)
+		return C.xed_imm0(C.xed_uint64_t(imm), 32)
 	case argInt8:
-		return C.xed_simm0(C.xed_int32_t(req.imm), 8)
+		return C.xed_simm0(C.xed_int32_t(imm), 8)
 	case argInt16:
-		return C.xed_simm0(C.xed_int32_t(req.imm), 16)
+		return C.xed_simm0(C.xed_int32_t(imm), 16)
 	case argInt32:
-		return C.xed_simm0(C.xed_int32_t(req.imm), 32)
+		return C.xed_simm0(C.xed_int32_t(imm), 32)
+	case argUint64, argInt64:
+		return C.xed_imm0(C.xed_uint64_t(imm), 64)
 	case argMem:
-		return xedMemOperand(req, int(req.memWidth))
+		return xedMemOperand(state, req, index)
 	case argRel8:
 		return C.xed_relbr(C.xed_int32_t(req.rel), 8)
 	case argRel16:
@@ -268,3 +834,10 @@ func (b *buffer) GoBytes(length int) []byte {
 	copy(goBytes, b.data[:])
 	return goBytes
 }
+
+func cbool(v bool) C.int {
+	if v {
+		return 1
+	}
+	return 0
+}
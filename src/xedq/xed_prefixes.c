@@ -0,0 +1,10 @@
+#include <xed/xed-interface.h>
+
+// Prefixes are bitfields, which are not accessible from Go.
+void xedqSetPrefixes(xed_encoder_instruction_t* x, int lock, int rep, int repne, int taken, int notTaken) {
+	x->prefixes.s.lock = lock;
+	x->prefixes.s.rep = rep;
+	x->prefixes.s.repne = repne;
+	x->prefixes.s.br_hint_taken = taken;
+	x->prefixes.s.br_hint_not_taken = notTaken;
+}
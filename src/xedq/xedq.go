@@ -7,6 +7,9 @@ func InitTables() {
 }
 
 // Ptr describes effective address computation.
+//
+// For 16bit addressing, Base is BX or BP and Index is SI or DI,
+// like in [BX+SI+disp]. Single BX, BP, SI or DI is used as Base.
 type Ptr struct {
 	// Base register name. SIB - B.
 	// Empty string means "no base".
@@ -14,6 +17,8 @@ type Ptr struct {
 
 	// Index register name. SIB - I.
 	// Empty string means "no scaled index".
+	// XMM, YMM and ZMM registers are used as VSIB index
+	// for gather and scatter instructions.
 	Index string
 
 	// Scaling factor. SIB - S.
@@ -22,8 +27,15 @@ type Ptr struct {
 	// which usually implies scaling factor of 1.
 	Scale uint8
 
-	// 32bit pointer displacement.
+	// Pointer displacement.
 	// Displacement is encoded as 8 or 32 bit immediate value.
-	// Exceptions like MOVABS are not handled (yet?).
-	Disp int32
+	// For 16 and 32bit addressing, unsigned values are accepted too,
+	// like 0xc0000000, they are truncated to the address size.
+	// 64bit displacement is only valid for absolute memory offset
+	// (moffs) forms of MOV, where both Base and Index are empty.
+	Disp int64
+
+	// Segment register name, like "FS" or "GS".
+	// Empty string means "default segment".
+	Seg string
 }